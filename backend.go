@@ -0,0 +1,44 @@
+package rxfsnotify
+
+import (
+	"github.com/atmshang/rxfsnotify/fs"
+	"github.com/fsnotify/fsnotify"
+	"time"
+)
+
+// BackendEvent 是后端上报的原始变化，Watcher 只用 Path 去更新快照
+type BackendEvent struct {
+	Path string
+	Op   fsnotify.Op
+	// Time 是后端读到变化的时间，为零时使用 Watcher 收到事件的时间
+	Time time.Time
+}
+
+// Backend 是文件变化的来源。一个 Backend 可以同时服务多个根目录，
+// 传给 Watcher 之后由 Watcher 负责 Close。
+type Backend interface {
+	// Add 递归地开始监听 root，filter 排除的目录不需要监听，filter 可能为 nil
+	Add(root string, filter fs.Filter) error
+	// Remove 停止监听 root 以及它下面的路径
+	Remove(root string) error
+	Events() <-chan BackendEvent
+	Errors() <-chan error
+	Close() error
+}
+
+// SymlinkFollower 由能跟随符号链接监听的后端实现，内置的后端都实现了它。
+// 开启 WithFollowSymlinks 的根目录用 AddFollowingSymlinks 代替 Add，链接指向的目录也会被监听，
+// 指回上级目录的链接不会被重复进入
+type SymlinkFollower interface {
+	AddFollowingSymlinks(root string, filter fs.Filter) error
+}
+
+// addRoot 按根目录的选项让 backend 开始监听 path，后端不支持跟随符号链接时只监听链接本身
+func (r *watchedRoot) addRoot(backend Backend, path string, filter fs.Filter) error {
+	if r.opts.followSymlinks {
+		if follower, ok := backend.(SymlinkFollower); ok {
+			return follower.AddFollowingSymlinks(path, filter)
+		}
+	}
+	return backend.Add(path, filter)
+}
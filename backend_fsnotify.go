@@ -0,0 +1,375 @@
+package rxfsnotify
+
+import (
+	"errors"
+	"fmt"
+	"github.com/atmshang/plog"
+	"github.com/atmshang/rxfsnotify/fs"
+	"github.com/fsnotify/fsnotify"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// fsnotifyBackend 基于 fsnotify 递归监听，新建的目录会自动加入监听
+type fsnotifyBackend struct {
+	watcher *fsnotify.Watcher
+
+	roots     map[string]fs.Filter
+	addLocker sync.Mutex
+	// follow 记录哪些根目录跟随符号链接
+	follow map[string]bool
+	// unwatched 是因为 inotify 监听数量达到上限而没能监听的目录
+	unwatched map[string]bool
+
+	events    chan BackendEvent
+	errors    chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewFsnotifyBackend 创建基于 fsnotify 的后端，这是 Watcher 的默认后端
+func NewFsnotifyBackend() (Backend, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	b := &fsnotifyBackend{
+		watcher:   watcher,
+		roots:     make(map[string]fs.Filter),
+		follow:    make(map[string]bool),
+		unwatched: make(map[string]bool),
+		events:    make(chan BackendEvent),
+		errors:    make(chan error),
+		done:      make(chan struct{}),
+	}
+	go b.eventHandler()
+	return b, nil
+}
+
+func (b *fsnotifyBackend) Add(root string, filter fs.Filter) error {
+	return b.add(root, filter, false)
+}
+
+// AddFollowingSymlinks 同 Add，但会进入指向目录的符号链接，用链接下的路径监听目标目录
+func (b *fsnotifyBackend) AddFollowingSymlinks(root string, filter fs.Filter) error {
+	return b.add(root, filter, true)
+}
+
+func (b *fsnotifyBackend) add(root string, filter fs.Filter, follow bool) error {
+	// 根目录本身必须能被监听，子目录的失败只会导致它们收不到事件
+	err := b.addWatchedPaths(root)
+	if err != nil {
+		return err
+	}
+	b.addLocker.Lock()
+	b.roots[root] = filter
+	b.follow[root] = follow
+	b.addLocker.Unlock()
+
+	b.refreshWatchedPaths([]string{root})
+	return nil
+}
+
+func (b *fsnotifyBackend) Remove(root string) error {
+	b.addLocker.Lock()
+	defer b.addLocker.Unlock()
+
+	delete(b.roots, root)
+	delete(b.follow, root)
+	for _, p := range b.watcher.WatchList() {
+		if isSubPath(root, p) && !b.coveredLocked(p) {
+			_ = b.watcher.Remove(p)
+		}
+	}
+	for p := range b.unwatched {
+		if isSubPath(root, p) && !b.coveredLocked(p) {
+			delete(b.unwatched, p)
+		}
+	}
+	return nil
+}
+
+// Unwatched 返回因为 inotify 监听数量达到上限而没有被监听的目录
+func (b *fsnotifyBackend) Unwatched() []string {
+	b.addLocker.Lock()
+	defer b.addLocker.Unlock()
+
+	var result []string
+	for p := range b.unwatched {
+		result = append(result, p)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// 路径是否还属于其他根目录
+func (b *fsnotifyBackend) coveredLocked(p string) bool {
+	for root := range b.roots {
+		if isSubPath(root, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *fsnotifyBackend) Events() <-chan BackendEvent {
+	return b.events
+}
+
+func (b *fsnotifyBackend) Errors() <-chan error {
+	return b.errors
+}
+
+func (b *fsnotifyBackend) Close() error {
+	err := error(nil)
+	b.closeOnce.Do(func() {
+		close(b.done)
+		err = b.watcher.Close()
+	})
+	return err
+}
+
+// 开始监视路径的变化。
+// 一个路径只能被监视一次；尝试多次监视同一路径将返回错误。尚不存在于文件系统上的路径无法被添加监视。如果路径被删除，监视将自动删除。
+// 如果将路径重命名到同一文件系统上的其他位置，监视将保持不变，但是如果路径被删除并重新创建，或者被移动到不同的文件系统，监视将被删除。
+// 在网络文件系统（NFS、SMB、FUSE 等）或特殊文件系统（/proc、/sys 等）上通常无法正常工作。
+// 所有目录中的文件都将被监视，包括在观察器启动后创建的新文件。子目录不会被监视（即非递归）。
+// 通常不建议仅监视单个文件（而不是目录），因为许多工具以原子方式更新文件。而不是直接写入文件，首先会写入临时文件，如果成功，则将临时文件移动到目标位置，删除原始文件，或者进行某种变体。原始文件上的监视器现在丢失了，因为它不再存在。
+// 相反，监视父目录并使用 Event.Name 过滤您不感兴趣的文件。在 [cmd/fsnotify/file.go] 中有一个示例。
+func (b *fsnotifyBackend) refreshWatchedPaths(dirPaths []string) {
+
+	watchedPaths := make(map[string]bool)
+
+	for _, dirPath := range dirPaths {
+		root, filter, follow := b.rootOf(dirPath)
+		traverseDir(watchedPaths, dirPath, func(p string) bool {
+			return filter != nil && filter.Excluded(root, p, true)
+		}, follow, ancestorsOf(root, dirPath, follow))
+	}
+	var finalPaths []string
+	for p := range watchedPaths {
+		finalPaths = append(finalPaths, p)
+	}
+
+	// 其他错误一般是目录已经被删除，只有达到上限需要上报
+	var unwatched []string
+	for _, dirPath := range finalPaths {
+		err := b.addWatchedPaths(dirPath)
+		if errors.Is(err, syscall.ENOSPC) {
+			unwatched = append(unwatched, dirPath)
+		}
+	}
+	if len(unwatched) > 0 {
+		sort.Strings(unwatched)
+		limits, _ := ReadInotifyLimits()
+		go b.sendError(&WatchLimitError{Dirs: unwatched, Limits: limits})
+	}
+}
+
+// Refresh 按过滤器现在的规则重新监听 dir 下的目录，忽略规则变化后由 Watcher 调用：
+// 不再被排除的目录加入监听，新被排除的目录移除监听
+func (b *fsnotifyBackend) Refresh(dir string) {
+	root, filter, follow := b.rootOf(dir)
+	if root == "" {
+		return
+	}
+	keep := make(map[string]bool)
+	traverseDir(keep, dir, func(p string) bool {
+		return filter != nil && filter.Excluded(root, p, true)
+	}, follow, ancestorsOf(root, dir, follow))
+
+	b.addLocker.Lock()
+	for _, p := range b.watcher.WatchList() {
+		// 嵌套的根目录有自己的过滤器
+		if isSubPath(dir, p) && !keep[p] && b.rootOfLocked(p) == root {
+			_ = b.watcher.Remove(p)
+		}
+	}
+	for p := range b.unwatched {
+		if isSubPath(dir, p) && !keep[p] && b.rootOfLocked(p) == root {
+			delete(b.unwatched, p)
+		}
+	}
+	b.addLocker.Unlock()
+
+	b.refreshWatchedPaths([]string{dir})
+}
+
+// sendError 不能阻塞调用方，Add 时 Watcher 可能还没开始读取错误
+func (b *fsnotifyBackend) sendError(err error) {
+	select {
+	case b.errors <- err:
+	case <-b.done:
+	}
+}
+
+// 找到 p 所属的根目录、它的过滤器和是否跟随符号链接，根目录嵌套时取最深的那个
+func (b *fsnotifyBackend) rootOf(p string) (string, fs.Filter, bool) {
+	b.addLocker.Lock()
+	defer b.addLocker.Unlock()
+
+	found := b.rootOfLocked(p)
+	return found, b.roots[found], b.follow[found]
+}
+
+func (b *fsnotifyBackend) rootOfLocked(p string) string {
+	found := ""
+	for root := range b.roots {
+		if isSubPath(root, p) && len(root) > len(found) {
+			found = root
+		}
+	}
+	return found
+}
+
+// 被排除的目录不会占用 inotify 的监听数量。
+// follow 时会进入指向目录的符号链接，ancestors 是上级目录，指回它们的链接会造成无限循环，按设备号和 inode 跳过
+// ancestorsOf 返回 dirPath 到根目录之间的上级目录，从中间开始遍历时也能发现指回它们的链接
+func ancestorsOf(root string, dirPath string, follow bool) []os.FileInfo {
+	if !follow {
+		return nil
+	}
+	var result []os.FileInfo
+	for p := dirPath; p != root; {
+		parent := filepath.Dir(p)
+		if parent == p {
+			break
+		}
+		p = parent
+		if info, err := os.Stat(p); err == nil {
+			result = append(result, info)
+		}
+	}
+	return result
+}
+
+func traverseDir(watchedPaths map[string]bool, dirPath string, excluded func(string) bool, follow bool, ancestors []os.FileInfo) {
+	if excluded(dirPath) {
+		return
+	}
+	if follow {
+		// 同一个目录只能通过一个路径监听，先检查再加入
+		self, err := os.Stat(dirPath)
+		if err != nil {
+			return
+		}
+		for _, ancestor := range ancestors {
+			if os.SameFile(ancestor, self) {
+				return
+			}
+		}
+		ancestors = append(ancestors[:len(ancestors):len(ancestors)], self)
+	}
+	watchedPaths[dirPath] = true
+
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		plog.Println(err)
+		return
+	}
+
+	for _, f := range files {
+		fp := filepath.Join(dirPath, f.Name())
+		if f.IsDir() {
+			traverseDir(watchedPaths, fp, excluded, follow, ancestors)
+		} else if follow && f.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(fp); err == nil && target.IsDir() {
+				traverseDir(watchedPaths, fp, excluded, follow, ancestors)
+			}
+		} else {
+			// 不需要管文件
+		}
+	}
+}
+
+func (b *fsnotifyBackend) addWatchedPaths(dirPath string) error {
+
+	b.addLocker.Lock()
+	defer b.addLocker.Unlock()
+
+	err := b.watcher.Remove(dirPath)
+	if err != nil {
+		//log.Println("[REMOVE4ADD] 移除观察失败：", err, dirPath)
+	} else {
+		//log.Println("[REMOVE4ADD] 移除观察成功：", dirPath)
+	}
+
+	err = b.watcher.Add(dirPath) //添加观察目录
+	if err != nil {
+		//log.Println("[ADD] 添加观察目录失败：", err, dirPath)
+		if errors.Is(err, syscall.ENOSPC) {
+			b.unwatched[dirPath] = true
+		}
+		return err
+	}
+	delete(b.unwatched, dirPath)
+	//log.Println("[ADD] 添加观察目录成功：", dirPath)
+	return nil
+}
+
+// fsnotify的文档有说，会自动移除不存在的监听，先不管他，但是rename的情况有bug，难顶
+func (b *fsnotifyBackend) removeWatch(event fsnotify.Event) {
+	b.addLocker.Lock()
+	defer b.addLocker.Unlock()
+
+	err := b.watcher.Remove(event.Name)
+	if err != nil {
+		//log.Println("[REMOVE] 移除观察失败：", err, event.Name)
+		return
+	}
+	//log.Println("[REMOVE] 移除观察成功：", event.Name)
+}
+
+func (b *fsnotifyBackend) eventHandler() {
+	for {
+		select {
+		case event, ok := <-b.watcher.Events:
+			if !ok {
+				plog.Println("监听事件管道发现：不OK")
+				return
+			}
+			// inotify 事件不带时间，只能记录读到事件的时间
+			observedAt := time.Now()
+
+			// 判断状态，和快照一样只在跟随符号链接时按链接的目标判断
+			_, _, follow := b.rootOf(event.Name)
+			stat, err := statPath(event.Name, follow)
+			if err != nil {
+				//plog.Println("这个文件不在啦：", event)
+				b.removeWatch(event)
+			} else {
+				//plog.Println("这个文件夹还在：", event)
+				if stat.IsDir() {
+					// 移动进来的目录可能带着子目录，需要递归加入
+					b.refreshWatchedPaths([]string{event.Name})
+				}
+			}
+
+			select {
+			case b.events <- BackendEvent{Path: event.Name, Op: event.Op, Time: observedAt}:
+			case <-b.done:
+				return
+			}
+		case err, ok := <-b.watcher.Errors:
+			if !ok {
+				plog.Println("监听错误管道发现：不OK")
+				return
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				err = fmt.Errorf("%w: %w", ErrOverflow, err)
+			}
+			select {
+			case b.errors <- err:
+			case <-b.done:
+				return
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
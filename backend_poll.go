@@ -0,0 +1,167 @@
+package rxfsnotify
+
+import (
+	"github.com/atmshang/rxfsnotify/fs"
+	"github.com/fsnotify/fsnotify"
+	"sync"
+	"time"
+)
+
+// pollingBackend 定期重新扫描根目录并和上一次的结果对比，
+// 适用于 fsnotify 无法工作的 NFS、SMB、FUSE 等文件系统
+type pollingBackend struct {
+	interval time.Duration
+
+	roots map[string]*fs.FileSystem
+	// opts 是每个根目录建立快照时使用的选项
+	opts   map[string][]fs.Option
+	locker sync.Mutex
+
+	events    chan BackendEvent
+	errors    chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// defaultPollInterval 是 interval 不是正数时使用的轮询间隔
+const defaultPollInterval = time.Second
+
+// NewPollingBackend 创建每隔 interval 扫描一次的轮询后端，interval 不是正数时每秒扫描一次
+func NewPollingBackend(interval time.Duration) Backend {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	b := &pollingBackend{
+		interval: interval,
+		roots:    make(map[string]*fs.FileSystem),
+		opts:     make(map[string][]fs.Option),
+		events:   make(chan BackendEvent),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *pollingBackend) Add(root string, filter fs.Filter) error {
+	return b.add(root, fs.WithFilter(filter))
+}
+
+func (b *pollingBackend) AddFollowingSymlinks(root string, filter fs.Filter) error {
+	return b.add(root, fs.WithFilter(filter), fs.WithFollowSymlinks())
+}
+
+func (b *pollingBackend) add(root string, opts ...fs.Option) error {
+	tree, err := fs.NewFileSystem(root, opts...)
+	if err != nil {
+		return err
+	}
+	b.locker.Lock()
+	b.roots[root] = tree
+	b.opts[root] = opts
+	b.locker.Unlock()
+	return nil
+}
+
+func (b *pollingBackend) Remove(root string) error {
+	b.locker.Lock()
+	delete(b.roots, root)
+	delete(b.opts, root)
+	b.locker.Unlock()
+	return nil
+}
+
+func (b *pollingBackend) Events() <-chan BackendEvent {
+	return b.events
+}
+
+func (b *pollingBackend) Errors() <-chan error {
+	return b.errors
+}
+
+func (b *pollingBackend) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+	return nil
+}
+
+func (b *pollingBackend) run() {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.poll()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *pollingBackend) poll() {
+	b.locker.Lock()
+	roots := make(map[string]*fs.FileSystem, len(b.roots))
+	opts := make(map[string][]fs.Option, len(b.opts))
+	for root, tree := range b.roots {
+		roots[root] = tree
+		opts[root] = b.opts[root]
+	}
+	b.locker.Unlock()
+
+	for root, previous := range roots {
+		current, err := fs.NewFileSystem(root, opts[root]...)
+		if err != nil {
+			b.sendError(err)
+			continue
+		}
+		now := time.Now()
+
+		b.locker.Lock()
+		_, ok := b.roots[root]
+		if ok {
+			b.roots[root] = current
+		}
+		b.locker.Unlock()
+		if !ok {
+			// 扫描期间被移除了
+			continue
+		}
+
+		for _, diff := range previous.Diff(current) {
+			if diff.Op == fs.Renamed {
+				b.send(BackendEvent{Path: diff.OldAbsPath, Op: fsnotify.Rename, Time: now})
+			}
+			b.send(BackendEvent{Path: diff.AbsPath, Op: pollOp(diff.Op), Time: now})
+		}
+	}
+}
+
+// 把快照差异翻译成对应的 fsnotify 操作
+func pollOp(op fs.Op) fsnotify.Op {
+	switch op {
+	case fs.Removed:
+		return fsnotify.Remove
+	case fs.Modified:
+		return fsnotify.Write
+	case fs.AttribChanged:
+		return fsnotify.Chmod
+	default:
+		return fsnotify.Create
+	}
+}
+
+func (b *pollingBackend) send(event BackendEvent) {
+	select {
+	case b.events <- event:
+	case <-b.done:
+	}
+}
+
+func (b *pollingBackend) sendError(err error) {
+	select {
+	case b.errors <- err:
+	case <-b.done:
+	}
+}
@@ -0,0 +1,30 @@
+package rxfsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPollingBackendWithoutInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		root := t.TempDir()
+		b := NewPollingBackend(interval)
+		if err := b.Add(root, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, "a"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case e := <-b.Events():
+			if e.Path != filepath.Join(root, "a") {
+				t.Errorf("interval %v: got an event for %s", interval, e.Path)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("interval %v: no event", interval)
+		}
+		b.Close()
+	}
+}
@@ -0,0 +1,234 @@
+package rxfsnotify
+
+import (
+	"sync"
+)
+
+// Event 是通过 Events 通道收到的事件，和回调收到的 CallBackEvent 相同
+type Event = CallBackEvent
+
+// OverflowPolicy 决定 Events 通道满了之后如何处理新事件
+type OverflowPolicy int
+
+const (
+	// OverflowBlock 阻塞直到消费方取走事件，不会丢失事件，但会拖慢后续事件的上报
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest 丢弃通道里最旧的事件来放入新事件
+	OverflowDropOldest
+	// OverflowCoalesce 把同一路径还没被取走的事件合并成一个，例如 Created 之后的 Modified
+	// 仍然是 Created，Created 之后的 Removed 两者都不再上报
+	OverflowCoalesce
+)
+
+// eventChannel 实现 Events 和 Errors 通道，第一次调用 Events 或 Errors 之后才开始投递
+type eventChannel struct {
+	policy OverflowPolicy
+	ch     chan Event
+	errCh  chan error
+
+	locker        sync.Mutex
+	enabled       bool
+	errorsEnabled bool
+	closed        bool
+	closing       chan struct{}
+	senders       sync.WaitGroup
+
+	// 合并策略下还没送进通道的事件，按路径第一次出现的顺序排列
+	pending       []string
+	pendingEvents map[string]Event
+	wake          chan struct{}
+}
+
+func newEventChannel(size int, policy OverflowPolicy) *eventChannel {
+	size = max(size, 0)
+	if policy != OverflowBlock {
+		// 丢弃和合并都需要通道里至少能放下一个事件，否则没有消费方时永远送不进去
+		size = max(size, 1)
+	}
+	return &eventChannel{
+		policy:        policy,
+		ch:            make(chan Event, size),
+		errCh:         make(chan error, size),
+		closing:       make(chan struct{}),
+		pendingEvents: make(map[string]Event),
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+func (c *eventChannel) events() <-chan Event {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	if !c.enabled && !c.closed {
+		c.enabled = true
+		if c.policy == OverflowCoalesce {
+			c.senders.Add(1)
+			go c.pump()
+		}
+	}
+	return c.ch
+}
+
+func (c *eventChannel) errors() <-chan error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	c.errorsEnabled = true
+	return c.errCh
+}
+
+func (c *eventChannel) publish(e Event) {
+	c.locker.Lock()
+	if !c.enabled || c.closed {
+		c.locker.Unlock()
+		return
+	}
+
+	switch c.policy {
+	case OverflowCoalesce:
+		c.coalesceLocked(e)
+		c.locker.Unlock()
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+		return
+	case OverflowDropOldest:
+		defer c.locker.Unlock()
+		for {
+			select {
+			case c.ch <- e:
+				return
+			default:
+			}
+			select {
+			case <-c.ch:
+			default:
+			}
+		}
+	}
+
+	c.senders.Add(1)
+	c.locker.Unlock()
+	defer c.senders.Done()
+
+	select {
+	case c.ch <- e:
+	case <-c.closing:
+	}
+}
+
+// publishError 不会阻塞，通道满了就丢弃新的错误
+func (c *eventChannel) publishError(err error) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	if !c.errorsEnabled || c.closed {
+		return
+	}
+	select {
+	case c.errCh <- err:
+	default:
+	}
+}
+
+func (c *eventChannel) coalesceLocked(e Event) {
+	old, ok := c.pendingEvents[e.Path]
+	if !ok {
+		c.pending = append(c.pending, e.Path)
+		c.pendingEvents[e.Path] = e
+		return
+	}
+
+	path := e.Path
+	e, ok = mergeEvents(old, e)
+	if !ok || e.Path != path {
+		delete(c.pendingEvents, path)
+		for i, p := range c.pending {
+			if p == path {
+				c.pending = append(c.pending[:i], c.pending[i+1:]...)
+				break
+			}
+		}
+		if ok {
+			// 合并后变成了另一个路径的事件，和那个路径还没投递的事件继续合并
+			c.coalesceLocked(e)
+		}
+		return
+	}
+	c.pendingEvents[e.Path] = e
+}
+
+// mergeEvents 把同一路径先后两个还没投递的事件合并成一个，两者相互抵消时返回 false。
+// 移动过来又被删除时合并成旧路径的 Removed，调用方需要按新的 Path 重新归类
+func mergeEvents(old, e Event) (Event, bool) {
+	switch {
+	case old.Op == Created && e.Op == Removed:
+		// 出现又消失，对消费方来说什么都没发生
+		return e, false
+	case old.Op == Renamed && e.Op == Removed:
+		// 消费方从没见过新路径，只需要知道旧路径已经不在了
+		e.Path, e.OldPath = old.OldPath, ""
+	case old.Op == Created && (e.Op == Modified || e.Op == AttribChanged || e.Op == Retargeted):
+		e.Op = Created
+	case old.Op == Renamed && e.Op != Removed:
+		e.Op, e.OldPath = Renamed, old.OldPath
+	}
+	return e, true
+}
+
+// pump 把合并后的事件依次送进通道
+func (c *eventChannel) pump() {
+	defer c.senders.Done()
+
+	for {
+		c.locker.Lock()
+		if len(c.pending) == 0 {
+			c.locker.Unlock()
+			select {
+			case <-c.wake:
+				continue
+			case <-c.closing:
+				return
+			}
+		}
+		path := c.pending[0]
+		c.pending = c.pending[1:]
+		e := c.pendingEvents[path]
+		delete(c.pendingEvents, path)
+		c.locker.Unlock()
+
+		select {
+		case c.ch <- e:
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+// close 等待正在投递的事件放弃之后关闭两个通道
+func (c *eventChannel) close() {
+	c.locker.Lock()
+	if c.closed {
+		c.locker.Unlock()
+		return
+	}
+	c.closed = true
+	close(c.closing)
+	c.locker.Unlock()
+
+	c.senders.Wait()
+	close(c.ch)
+	close(c.errCh)
+}
+
+// Events 返回事件通道，可以和回调同时使用。第一次调用之后才开始投递，
+// 通道满了之后的行为由 WithOverflowPolicy 决定，Close 之后通道会被关闭
+func (w *Watcher) Events() <-chan Event {
+	return w.channel.events()
+}
+
+// Errors 返回后端和内部错误的通道，第一次调用之后才开始投递，满了之后新的错误会被丢弃
+func (w *Watcher) Errors() <-chan error {
+	return w.channel.errors()
+}
@@ -0,0 +1,122 @@
+package rxfsnotify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMergeEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		old, e Event
+		want   Event
+		ok     bool
+	}{
+		{
+			name: "created then removed cancel out",
+			old:  Event{Path: "/r/a", Op: Created},
+			e:    Event{Path: "/r/a", Op: Removed},
+			ok:   false,
+		},
+		{
+			name: "created then modified stays created",
+			old:  Event{Path: "/r/a", Op: Created},
+			e:    Event{Path: "/r/a", Op: Modified, Size: 3},
+			want: Event{Path: "/r/a", Op: Created, Size: 3},
+			ok:   true,
+		},
+		{
+			name: "created then retargeted stays created",
+			old:  Event{Path: "/r/l", Op: Created, Target: "x"},
+			e:    Event{Path: "/r/l", Op: Retargeted, Target: "y"},
+			want: Event{Path: "/r/l", Op: Created, Target: "y"},
+			ok:   true,
+		},
+		{
+			name: "renamed then modified keeps the old path",
+			old:  Event{Path: "/r/b", OldPath: "/r/a", Op: Renamed},
+			e:    Event{Path: "/r/b", Op: Modified},
+			want: Event{Path: "/r/b", OldPath: "/r/a", Op: Renamed},
+			ok:   true,
+		},
+		{
+			name: "renamed then removed removes the old path",
+			old:  Event{Path: "/r/b", OldPath: "/r/a", Op: Renamed},
+			e:    Event{Path: "/r/b", Op: Removed},
+			want: Event{Path: "/r/a", Op: Removed},
+			ok:   true,
+		},
+		{
+			name: "modified then removed is removed",
+			old:  Event{Path: "/r/a", Op: Modified},
+			e:    Event{Path: "/r/a", Op: Removed},
+			want: Event{Path: "/r/a", Op: Removed},
+			ok:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := mergeEvents(tt.old, tt.e)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoalesceRenamedThenRemoved(t *testing.T) {
+	c := newEventChannel(0, OverflowCoalesce)
+	c.coalesceLocked(Event{Path: "/r/a", Op: Modified})
+	c.coalesceLocked(Event{Path: "/r/b", OldPath: "/r/a", Op: Renamed})
+	c.coalesceLocked(Event{Path: "/r/b", Op: Removed})
+
+	if len(c.pending) != 1 || c.pending[0] != "/r/a" {
+		t.Fatalf("pending = %v, want [/r/a]", c.pending)
+	}
+	if got := c.pendingEvents["/r/a"]; got.Op != Removed {
+		t.Errorf("event for /r/a = %+v, want Removed", got)
+	}
+	if _, ok := c.pendingEvents["/r/b"]; ok {
+		t.Errorf("event for /r/b is still pending")
+	}
+}
+
+func TestDropOldestWithoutBuffer(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowDropOldest, OverflowCoalesce} {
+		c := newEventChannel(0, policy)
+		ch := c.events()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			c.publish(Event{Path: "/r/a", Op: Created})
+			c.publish(Event{Path: "/r/b", Op: Created})
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("policy %d: publish blocked without a reader", policy)
+		}
+		select {
+		case e := <-ch:
+			if policy == OverflowDropOldest && e.Path != "/r/b" {
+				t.Errorf("policy %d: got %s, want the newest event", policy, e.Path)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("policy %d: no event delivered", policy)
+		}
+
+		closed := make(chan struct{})
+		go func() {
+			c.close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("policy %d: close blocked", policy)
+		}
+	}
+}
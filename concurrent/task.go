@@ -18,6 +18,7 @@ type TaskQueue struct {
 	tasks     []*Task
 	counter   uint64
 	newTask   chan *Task
+	stopCh    chan struct{}
 	isRunning bool
 }
 
@@ -27,10 +28,15 @@ func NewTaskQueue() *TaskQueue {
 	}
 }
 
+// AddTask 在队列未启动或已停止时返回 nil，任务不会被执行
 func (tq *TaskQueue) AddTask(delay time.Duration, execute func()) *Task {
 	tq.mu.Lock()
 	defer tq.mu.Unlock()
 
+	if !tq.isRunning {
+		return nil
+	}
+
 	task := &Task{
 		ID:      tq.counter,
 		Delay:   delay,
@@ -61,7 +67,6 @@ func (tq *TaskQueue) CancelAll() {
 	}
 
 	tq.tasks = nil
-	tq.isRunning = false
 }
 
 func (tq *TaskQueue) Start() {
@@ -71,13 +76,20 @@ func (tq *TaskQueue) Start() {
 		return
 	}
 	tq.isRunning = true
+	tq.stopCh = make(chan struct{})
+	stopCh := tq.stopCh
 	tq.mu.Unlock()
 
 	tq.CancelAll()
 
 	go func() {
 		for {
-			task := <-tq.newTask
+			var task *Task
+			select {
+			case task = <-tq.newTask:
+			case <-stopCh:
+				return
+			}
 			// Start a new Goroutine for each new task.
 			plog.Println("未来执行新任务：", task.ID)
 			go func(task *Task) {
@@ -86,12 +98,29 @@ func (tq *TaskQueue) Start() {
 					plog.Println("执行任务：", task.ID)
 					tq.executeTask(task)
 					plog.Println("执行完成：", task.ID)
+				case <-stopCh:
 				}
 			}(task)
 		}
 	}()
 }
 
+// Stop 取消所有等待中的任务并结束调度协程，之后可以再次 Start
+func (tq *TaskQueue) Stop() {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+
+	for _, task := range tq.tasks {
+		task.Canceled = true
+	}
+	tq.tasks = nil
+
+	if tq.isRunning {
+		close(tq.stopCh)
+		tq.isRunning = false
+	}
+}
+
 func (tq *TaskQueue) getNextTask() *Task {
 	tq.mu.Lock()
 	defer tq.mu.Unlock()
@@ -107,7 +136,10 @@ func (tq *TaskQueue) getNextTask() *Task {
 }
 
 func (tq *TaskQueue) executeTask(task *Task) {
-	if task.Canceled {
+	tq.mu.Lock()
+	canceled := task.Canceled
+	tq.mu.Unlock()
+	if canceled {
 		plog.Println("任务执行前被取消：", task.ID)
 		return
 	}
@@ -0,0 +1,106 @@
+package rxfsnotify
+
+import (
+	"context"
+	"sync"
+)
+
+// deliveryQueue 按路径排队投递事件：同一路径的事件按发生顺序依次处理，
+// 不同路径的事件最多由 concurrency 个协程同时处理
+type deliveryQueue struct {
+	handle func(cbe CallBackEvent)
+	// drop 在停止时被调用，参数是被丢弃的事件数
+	drop func(dropped int)
+
+	locker  sync.Mutex
+	cond    *sync.Cond
+	queues  map[string][]CallBackEvent
+	active  map[string]bool
+	ready   []string
+	stopped bool
+}
+
+func newDeliveryQueue(ctx context.Context, concurrency int, handle func(cbe CallBackEvent), drop func(dropped int)) *deliveryQueue {
+	q := &deliveryQueue{
+		handle: handle,
+		drop:   drop,
+		queues: make(map[string][]CallBackEvent),
+		active: make(map[string]bool),
+	}
+	q.cond = sync.NewCond(&q.locker)
+
+	context.AfterFunc(ctx, q.stop)
+	for i := 0; i < max(concurrency, 1); i++ {
+		go q.work()
+	}
+	return q
+}
+
+// push 把事件排到它的路径后面，已经停止时返回 false
+func (q *deliveryQueue) push(cbe CallBackEvent) bool {
+	q.locker.Lock()
+	defer q.locker.Unlock()
+
+	if q.stopped {
+		return false
+	}
+	pending, ok := q.queues[cbe.Path]
+	q.queues[cbe.Path] = append(pending, cbe)
+	if !ok && !q.active[cbe.Path] {
+		q.ready = append(q.ready, cbe.Path)
+		q.cond.Signal()
+	}
+	return true
+}
+
+// stop 让协程处理完手上的事件后退出，还在排队的事件被丢弃
+func (q *deliveryQueue) stop() {
+	q.locker.Lock()
+	defer q.locker.Unlock()
+
+	q.stopped = true
+	dropped := 0
+	for _, pending := range q.queues {
+		dropped += len(pending)
+	}
+	q.drop(dropped)
+	q.queues = make(map[string][]CallBackEvent)
+	q.ready = nil
+	q.cond.Broadcast()
+}
+
+func (q *deliveryQueue) work() {
+	q.locker.Lock()
+	defer q.locker.Unlock()
+
+	for {
+		for len(q.ready) == 0 && !q.stopped {
+			q.cond.Wait()
+		}
+		if q.stopped {
+			return
+		}
+
+		path := q.ready[0]
+		q.ready = q.ready[1:]
+		pending := q.queues[path]
+		cbe := pending[0]
+		if len(pending) == 1 {
+			delete(q.queues, path)
+		} else {
+			q.queues[path] = pending[1:]
+		}
+		q.active[path] = true
+
+		q.locker.Unlock()
+		q.handle(cbe)
+		q.locker.Lock()
+
+		delete(q.active, path)
+		if _, ok := q.queues[path]; ok {
+			// 排到最后，避免一个路径的大量事件占住协程
+			q.ready = append(q.ready, path)
+			q.cond.Signal()
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package rxfsnotify
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeliveryQueueOrderPerPath(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release := make(chan struct{})
+	fast := make(chan uint64, 10)
+	var locker sync.Mutex
+	var slow []uint64
+	q := newDeliveryQueue(ctx, 4, func(cbe CallBackEvent) {
+		if cbe.Path != "/r/slow" {
+			fast <- cbe.Seq
+			return
+		}
+		locker.Lock()
+		slow = append(slow, cbe.Seq)
+		locker.Unlock()
+		<-release
+	}, func(int) {})
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		q.push(CallBackEvent{Path: "/r/slow", Seq: seq})
+	}
+	// 慢的路径占着一个协程时，其他路径的事件照常按顺序送达
+	for seq := uint64(4); seq <= 8; seq++ {
+		q.push(CallBackEvent{Path: "/r/fast", Seq: seq})
+		select {
+		case got := <-fast:
+			if got != seq {
+				t.Fatalf("fast path got seq %d, want %d", got, seq)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("seq %d on another path is blocked by the slow callback", seq)
+		}
+	}
+
+	// 同一路径的下一个事件要等上一个处理完
+	locker.Lock()
+	if !slices.Equal(slow, []uint64{1}) {
+		t.Errorf("slow path handled %v while the first event was blocked", slow)
+	}
+	locker.Unlock()
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		locker.Lock()
+		got := slices.Clone(slow)
+		locker.Unlock()
+		if len(got) == 3 {
+			if !slices.Equal(got, []uint64{1, 2, 3}) {
+				t.Errorf("slow path handled %v, want [1 2 3]", got)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("slow path handled %v, want [1 2 3]", got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,81 @@
+package rxfsnotify
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+var (
+	ErrRootNotExist     = errors.New("rxfsnotify: root does not exist")
+	ErrRootNotDir       = errors.New("rxfsnotify: root is not a directory")
+	ErrRootExists       = errors.New("rxfsnotify: root is already watched")
+	ErrRootNotWatched   = errors.New("rxfsnotify: root is not watched")
+	ErrPermissionDenied = errors.New("rxfsnotify: permission denied")
+	// ErrWatchLimit 表示触达了 inotify 的 max_user_watches 或 max_user_instances 限制
+	ErrWatchLimit     = errors.New("rxfsnotify: inotify watch limit reached")
+	ErrAlreadyStarted = errors.New("rxfsnotify: watcher already started")
+	ErrClosed         = errors.New("rxfsnotify: watcher closed")
+	ErrNilHandler     = errors.New("rxfsnotify: nil handler")
+	// ErrOverflow 表示后端丢失了事件，例如 inotify 队列溢出。自定义后端可以在 Errors 中发送包装了它的错误，
+	// Watcher 会重新扫描该后端的根目录
+	ErrOverflow = errors.New("rxfsnotify: event queue overflowed, events were lost")
+)
+
+// RootError 记录某个根目录上发生的错误，可以用 errors.Is 判断 Kind 和底层错误
+type RootError struct {
+	Root string
+	// Kind 是上面的某个 Err* 哨兵错误，无法归类时为 nil
+	Kind error
+	// Err 是底层的原始错误，可能为 nil
+	Err error
+}
+
+func (e *RootError) Error() string {
+	switch {
+	case e.Kind != nil && errors.Is(e.Err, e.Kind):
+		// 底层错误已经包含了 Kind
+		return fmt.Sprintf("%s: %v", e.Root, e.Err)
+	case e.Kind != nil && e.Err != nil:
+		return fmt.Sprintf("%v: %s: %v", e.Kind, e.Root, e.Err)
+	case e.Kind != nil:
+		return fmt.Sprintf("%v: %s", e.Kind, e.Root)
+	default:
+		return fmt.Sprintf("rxfsnotify: %s: %v", e.Root, e.Err)
+	}
+}
+
+func (e *RootError) Unwrap() []error {
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// 把底层错误归类成 *RootError
+func classifyError(root string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var rootErr *RootError
+	if errors.As(err, &rootErr) {
+		return err
+	}
+
+	var kind error
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		kind = ErrRootNotExist
+	case errors.Is(err, fs.ErrPermission):
+		kind = ErrPermissionDenied
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EMFILE):
+		// inotify_add_watch 返回 ENOSPC，inotify_init 返回 EMFILE
+		kind = ErrWatchLimit
+	}
+	return &RootError{Root: root, Kind: kind, Err: err}
+}
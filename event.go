@@ -1,21 +1,90 @@
 package rxfsnotify
 
-type fileEvent struct {
-	Path  string
-	Event string
-}
+import (
+	"github.com/atmshang/rxfsnotify/fs"
+	"time"
+)
+
+// Op 是事件的类型，和 fs.Op 相同
+type Op = fs.Op
+
+const (
+	Removed       = fs.Removed
+	Created       = fs.Created
+	TypeChanged   = fs.TypeChanged
+	Modified      = fs.Modified
+	Renamed       = fs.Renamed
+	AttribChanged = fs.AttribChanged
+	Retargeted    = fs.Retargeted
+)
 
 type CallBackEvent struct {
-	Path  string
+	// Root 是事件所属的根目录
+	Root string
+	Path string
+	// OldPath 只在 Renamed 时有值，是移动前的路径
+	OldPath string
+	Op      Op
+	// Exist 表示事件发生后 Path 是否还存在，等价于 Op != Removed
 	Exist bool
+	// IsDir、Size、ModTime 描述事件发生后的节点，Removed 时描述被删除前的节点
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+	// Target 是符号链接指向的路径，Path 不是符号链接时为空
+	Target string
+	// CatchUp 表示这是启动时和状态文件对比得到的离线期间的变化
+	CatchUp bool
+	// Resync 表示后端丢失了事件（例如 inotify 队列溢出），这是重新扫描根目录后对比得到的变化
+	Resync bool
+	// Seq 是事件在 Watcher 内单调递增的编号，从 1 开始
+	Seq uint64
+	// ObservedAt 是后端第一次读到这个变化的时间，fsnotify 后端是从 inotify 队列中取出事件的时间，
+	// 队列积压时会晚于实际变化；离线期间的变化是启动扫描的时间
+	ObservedAt time.Time
+	// DeliveredAt 是事件交给回调、订阅者和通道的时间
+	DeliveredAt time.Time
+	// Redelivered 表示这是上次运行中投递过但没有被 Ack 的事件，Seq 和上次相同
+	Redelivered bool
+}
+
+// eventSource 表示事件是怎样得到的
+type eventSource int
+
+const (
+	sourceLive eventSource = iota
+	sourceCatchUp
+	sourceResync
+)
+
+func newCallBackEvent(rootPath string, diff fs.Diff, source eventSource) CallBackEvent {
+	return CallBackEvent{
+		Root:    rootPath,
+		Path:    diff.AbsPath,
+		OldPath: diff.OldAbsPath,
+		Op:      diff.Op,
+		Exist:   diff.Op != fs.Removed,
+		IsDir:   diff.IsDir,
+		Size:    diff.Size,
+		ModTime: diff.ModTime,
+		Target:  diff.Target,
+		CatchUp: source == sourceCatchUp,
+		Resync:  source == sourceResync,
+	}
 }
 
 type IPathCallback interface {
 	OnPathChanged(cbe CallBackEvent)
 }
 
-var cb IPathCallback
+// SetPathCallbackListener 设置回调，应在 Start 之前调用
+func (w *Watcher) SetPathCallbackListener(_cb IPathCallback) {
+	w.cb = _cb
+}
 
-func SetPathCallbackListener(_cb IPathCallback) {
-	cb = _cb
+// LastSequence 返回最近分配出去的 Seq，还没有事件时返回 0。
+// Seq 在投递之前分配，返回的事件可能还没有送到回调或通道，不能当作处理进度，
+// 需要进度时记录回调收到的 Seq，或者使用 WithJournal 和 Ack
+func (w *Watcher) LastSequence() uint64 {
+	return w.seq.Load()
 }
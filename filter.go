@@ -1,105 +1,115 @@
 package rxfsnotify
 
 import (
+	"context"
+	"github.com/atmshang/rxfsnotify/fs"
 	"github.com/reactivex/rxgo/v2"
+	"os"
 	"runtime/debug"
-	"sync"
+	"time"
 )
 
-var fileEventCh = make(chan rxgo.Item)
+// 发送事件到管道的方法，监听停止后直接丢弃
+func (w *Watcher) sendFileEvent(cbe CallBackEvent) {
+	w.fileEventLocker.RLock()
+	fileEventCh, done := w.fileEventCh, w.fileEventDone
+	w.fileEventLocker.RUnlock()
 
-// 发送事件到管道的方法，闻到味了.jpg
-func sendFileEvent(info fileEvent) {
-	defer func() {
-		if r := recover(); r != nil {
-			// plog.Println("recover:", r)
-		}
-	}()
-	fileEventCh <- rxgo.Item{V: info, E: nil}
-}
-
-func tryCloseFileEventCh() {
-	defer func() {
-		if r := recover(); r != nil {
-			// plog.Println("recover:", r)
-		}
-	}()
-	close(fileEventCh)
+	if fileEventCh == nil {
+		return
+	}
+	w.inflight.Add(1)
+	select {
+	case fileEventCh <- rxgo.Item{V: cbe, E: nil}:
+	case <-done:
+		w.inflight.Add(-1)
+	}
 }
 
-var eventFilterLocker sync.Mutex
-
-func fileFilter() {
-	tryCloseFileEventCh()
+func (w *Watcher) fileFilter(ctx context.Context, fileEventCh chan rxgo.Item) {
+	defer w.wg.Done()
 
-	eventFilterLocker.Lock()
-	defer eventFilterLocker.Unlock()
-
-	fileEventCh = make(chan rxgo.Item)
-	observable := rxgo.FromChannel(fileEventCh).
+	delivery := newDeliveryQueue(ctx, w.opts.concurrency, func(cbe CallBackEvent) {
+		w.dealWithFileEvent(ctx, cbe)
+		w.inflight.Add(-1)
+	}, func(dropped int) {
+		w.inflight.Add(-int64(dropped))
+	})
+	observable := rxgo.FromChannel(fileEventCh, rxgo.WithContext(ctx)).
 		//BufferWithTimeOrCount(rxgo.WithDuration(time.Millisecond*250), 5).
 		FlatMap(func(item rxgo.Item) rxgo.Observable {
 			return rxgo.Just(item.V)()
 		})
 	for item := range observable.Observe() {
-		info, ok := item.V.(fileEvent)
+		cbe, ok := item.V.(CallBackEvent)
 		if ok {
-			filePath := info.Path
-			// plog.Println("接收事件：", info)
-			go dealWithFileEvent(filePath)
+			// plog.Println("接收事件：", cbe)
+			if !delivery.push(cbe) {
+				w.inflight.Add(-1)
+			}
 		}
 	}
 }
 
-var rwFileMapLock sync.RWMutex
-var fileLocks = make(map[string]*sync.Mutex)
+func (w *Watcher) dealWithFileEvent(ctx context.Context, cbe CallBackEvent) {
+	// plog.Println("处理事件：", cbe)
 
-func getFileLock(filePath string) *sync.Mutex {
-	rwFileMapLock.RLock()
-	lock, ok := fileLocks[filePath]
-	rwFileMapLock.RUnlock()
+	opts := w.opts
+	if r := w.findRoot(cbe.Path); r != nil {
+		opts = r.opts
+	}
 
-	if !ok {
-		lock = &sync.Mutex{}
-		rwFileMapLock.Lock()
-		fileLocks[filePath] = lock
-		rwFileMapLock.Unlock()
+	if opts.writeCompletion != nil && needsWriteCompletion(cbe) {
+		// 会被阻塞在检查中，写完之后用最新的大小和修改时间上报
+		if opts.writeCompletion.Wait(ctx, cbe.Path, opts.settleInterval) {
+			if stat, err := os.Stat(cbe.Path); err == nil {
+				cbe.Size = stat.Size()
+				cbe.ModTime = stat.ModTime()
+			}
+		}
 	}
-	return lock
+
+	w.callback(cbe)
 }
 
-func dealWithFileEvent(filePath string) {
-	// 检查文件锁
-	lock := getFileLock(filePath)
-	ok := lock.TryLock()
-	if !ok {
-		// plog.Println("跳过事件：", filePath)
+// dispatch 把一个根目录的快照差异转换成回调事件，并按顺序编号。
+// observed 是后端观察到各路径变化的时间，没有记录的事件使用对比的时间
+func (w *Watcher) dispatch(r *watchedRoot, diffs []fs.Diff, observed map[string]time.Time, source eventSource) {
+	now := time.Now()
+	var events []CallBackEvent
+	for _, diff := range diffs {
+		diff, ok := r.filterDiff(diff)
+		if !ok {
+			continue
+		}
+		events = append(events, w.nextEvent(r, diff, observed, now, source))
+	}
+	if len(events) == 0 {
 		return
 	}
-	defer lock.Unlock()
-	// plog.Println("处理事件：", filePath)
 
-	// 会被阻塞在检查中
-	valid := checkFileUntilValidOrIdle(filePath)
-	if !valid {
-		// plog.Println("结果: File is not exist:", filePath)
-	} else {
-		// plog.Println("结果: File is changed:", filePath)
+	w.syncJournal()
+	for _, cbe := range events {
+		w.sendFileEvent(cbe)
 	}
-
-	go callback(filePath, valid)
-
 }
 
-func callback(filePath string, exist bool) {
-	if cb != nil {
-		cbe := CallBackEvent{Path: filePath, Exist: exist}
+// callback 把事件交给回调、订阅者和 Events 通道
+func (w *Watcher) callback(cbe CallBackEvent) {
+	cbe.DeliveredAt = time.Now()
+	if w.opts.recorder != nil {
+		w.opts.recorder.callback(cbe)
+	}
+	defer w.channel.publish(cbe)
+	defer w.publishSubscribers(cbe)
+
+	if w.cb != nil {
 		defer func() {
 			if r := recover(); r != nil {
 				// plog.Println("callback recover:", r)
 				debug.PrintStack()
 			}
 		}()
-		cb.OnPathChanged(cbe)
+		w.cb.OnPathChanged(cbe)
 	}
 }
@@ -0,0 +1,145 @@
+// Package filter decides which paths below a watched root are snapshotted,
+// watched and reported. It implements fs.Filter.
+package filter
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Filter combines include rules, exclude rules and .gitignore style files
+// found in the tree. A path is excluded when any of its parent directories
+// is excluded, when an exclude rule matches it, when the nearest ignore file
+// pattern matching it ignores it, or when it is a file and include rules are
+// set but none of them matches.
+type Filter struct {
+	include     []Rule
+	exclude     []Rule
+	ignoreFiles []string
+
+	// cache maps a directory to the patterns of its ignore files.
+	cache  map[string][]ignorePattern
+	locker sync.RWMutex
+}
+
+type Option func(*Filter)
+
+// Include only keeps files matching one of rules. Directories are never
+// dropped by include rules since files below them may match.
+func Include(rules ...Rule) Option {
+	return func(f *Filter) {
+		f.include = append(f.include, rules...)
+	}
+}
+
+// Exclude drops paths matching any of rules, and everything below an
+// excluded directory.
+func Exclude(rules ...Rule) Option {
+	return func(f *Filter) {
+		f.exclude = append(f.exclude, rules...)
+	}
+}
+
+// IgnoreFiles reads files with the given names, such as ".gitignore" and
+// ".ignore", in every directory of the tree. Patterns in deeper files take
+// precedence over those in their parents, and later lines over earlier ones.
+func IgnoreFiles(names ...string) Option {
+	return func(f *Filter) {
+		f.ignoreFiles = append(f.ignoreFiles, names...)
+	}
+}
+
+func New(opts ...Option) *Filter {
+	f := &Filter{cache: make(map[string][]ignorePattern)}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Excluded reports whether absPath below root should be left out.
+func (f *Filter) Excluded(root string, absPath string, isDir bool) bool {
+	rel, err := filepath.Rel(root, absPath)
+	// Names such as "..cache" are inside the root, only ".." itself leads out.
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := 1; i <= len(parts); i++ {
+		if f.excludedOne(root, parts[:i], i < len(parts) || isDir) {
+			return true
+		}
+	}
+	if !isDir && len(f.include) > 0 {
+		for _, rule := range f.include {
+			if rule.Match(strings.Join(parts, "/"), false) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (f *Filter) excludedOne(root string, parts []string, isDir bool) bool {
+	relPath := strings.Join(parts, "/")
+	for _, rule := range f.exclude {
+		if rule.Match(relPath, isDir) {
+			return true
+		}
+	}
+	if len(f.ignoreFiles) == 0 {
+		return false
+	}
+
+	// Walk the ignore files from the root down to the parent directory, the
+	// last matching pattern decides.
+	ignored := false
+	dir := root
+	for i := 0; i < len(parts); i++ {
+		if i > 0 {
+			dir = filepath.Join(dir, parts[i-1])
+		}
+		subPath := strings.Join(parts[i:], "/")
+		for _, p := range f.patterns(dir) {
+			if p.match(subPath, isDir) {
+				ignored = !p.negate
+			}
+		}
+	}
+	return ignored
+}
+
+func (f *Filter) patterns(dir string) []ignorePattern {
+	f.locker.RLock()
+	patterns, ok := f.cache[dir]
+	f.locker.RUnlock()
+	if ok {
+		return patterns
+	}
+
+	for _, name := range f.ignoreFiles {
+		patterns = append(patterns, parseIgnoreFile(filepath.Join(dir, name))...)
+	}
+	f.locker.Lock()
+	f.cache[dir] = patterns
+	f.locker.Unlock()
+	return patterns
+}
+
+// Reload drops the cached patterns when absPath is one of the ignore files
+// and reports whether it was, in which case the caller should rescan the
+// directory containing it.
+func (f *Filter) Reload(absPath string) bool {
+	name := filepath.Base(absPath)
+	for _, ignoreFile := range f.ignoreFiles {
+		if name == ignoreFile {
+			f.locker.Lock()
+			delete(f.cache, filepath.Dir(absPath))
+			f.locker.Unlock()
+			return true
+		}
+	}
+	return false
+}
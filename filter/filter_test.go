@@ -0,0 +1,126 @@
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.go", "a.go", true},
+		{"*.go", "a/b.go", false},
+		{"**/*.go", "a.go", true},
+		{"**/*.go", "a/b/c.go", true},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a/**/b", "x/a/b", false},
+		{"**/node_modules", "x/node_modules", true},
+		{"**/node_modules", "x/node_modules/y", false},
+		{"{a,b}/c", "b/c", true},
+		{"{a,b}/c", "d/c", false},
+		{"a.{go,md}", "a.md", true},
+		{"**/{node_modules,.git}", "x/.git", true},
+		{"{a", "{a", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			if got := MustGlob(tt.pattern).Match(tt.path, false); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".gitignore":          "# comment\n*.log\n!keep.log\n/build\ntmp/\ndocs/*.md\n\\#hash\n",
+		"sub/.gitignore":      "!*.log\n/local\n",
+		"sub/deep/.ignore":    "*.log\n",
+		"sub/deep/x/.gitkeep": "",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := New(
+		Exclude(MustGlob("**/{node_modules,.git}")),
+		IgnoreFiles(".gitignore", ".ignore"),
+	)
+	tests := []struct {
+		name  string
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"root itself", ".", true, false},
+		{"outside the root", "../other/a.log", false, false},
+		{"exclude rule", "a/node_modules", true, true},
+		{"below an excluded directory", "a/node_modules/b.js", false, true},
+		{"unanchored pattern", "a.log", false, true},
+		{"unanchored pattern at any depth", "x/y/a.log", false, true},
+		{"negation", "keep.log", false, false},
+		{"negation at any depth", "x/keep.log", false, false},
+		{"anchored pattern", "build", true, true},
+		{"below an anchored directory", "build/out.o", false, true},
+		{"anchored pattern deeper", "x/build", true, false},
+		{"directory pattern", "tmp", true, true},
+		{"directory pattern on a file", "tmp", false, false},
+		{"directory pattern at any depth", "x/tmp/a", false, true},
+		{"pattern with a slash", "docs/a.md", false, true},
+		{"star does not cross a slash", "docs/x/a.md", false, false},
+		{"pattern with a slash is anchored", "x/docs/a.md", false, false},
+		{"escaped hash", "#hash", false, true},
+		{"nested negation overrides the parent", "sub/a.log", false, false},
+		{"nested anchored pattern", "sub/local", true, true},
+		{"nested anchored pattern is relative to its file", "local", true, false},
+		{"nested anchored pattern deeper", "sub/x/local", true, false},
+		{"deeper file overrides the nested negation", "sub/deep/a.log", false, true},
+		{"deeper file keeps applying below", "sub/deep/x/a.log", false, true},
+		{"name starting with two dots", "..cache", true, false},
+		{"below a name starting with two dots", "..cache/a.log", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(root, filepath.FromSlash(tt.path))
+			if got := f.Excluded(root, p, tt.isDir); got != tt.want {
+				t.Errorf("Excluded(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncluded(t *testing.T) {
+	root := t.TempDir()
+	f := New(Include(MustGlob("**/*.go")), Exclude(MustGlob("vendor")))
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"a", true, false},
+		{"a/b.go", false, false},
+		{"a/b.txt", false, true},
+		{"vendor", true, true},
+		{"vendor/b.go", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			p := filepath.Join(root, filepath.FromSlash(tt.path))
+			if got := f.Excluded(root, p, tt.isDir); got != tt.want {
+				t.Errorf("Excluded(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
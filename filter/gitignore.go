@@ -0,0 +1,72 @@
+package filter
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// ignorePattern is one line of a .gitignore style file.
+type ignorePattern struct {
+	glob    []string
+	negate  bool
+	dirOnly bool
+}
+
+// parseIgnoreFile reads the patterns of an ignore file, a missing file has
+// no patterns.
+func parseIgnoreFile(absPath string) []ignorePattern {
+	f, err := os.Open(absPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if p, ok := parseIgnoreLine(scanner.Text()); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+func parseIgnoreLine(line string) (ignorePattern, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+
+	var p ignorePattern
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+	// A leading backslash escapes "#" and "!".
+	line = strings.TrimPrefix(line, "\\")
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignorePattern{}, false
+	}
+
+	// Without an inner slash the pattern matches a name at any depth,
+	// otherwise it is anchored to the directory of the ignore file.
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+	p.glob = strings.Split(line, "/")
+	return p, true
+}
+
+func (p ignorePattern) match(relPath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return matchSegments(p.glob, strings.Split(relPath, "/"))
+}
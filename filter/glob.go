@@ -0,0 +1,138 @@
+package filter
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Rule matches a slash separated path relative to the watched root.
+type Rule interface {
+	Match(relPath string, isDir bool) bool
+}
+
+type globRule struct {
+	patterns [][]string
+}
+
+// Glob returns a rule for a doublestar pattern: "**" matches any number of
+// path segments, "{a,b}" matches either alternative and everything else
+// follows path.Match within a single segment.
+func Glob(pattern string) (Rule, error) {
+	rule := &globRule{}
+	for _, p := range expandBraces(pattern) {
+		segments := strings.Split(strings.Trim(p, "/"), "/")
+		for _, segment := range segments {
+			if segment == "**" {
+				continue
+			}
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, err
+			}
+		}
+		rule.patterns = append(rule.patterns, segments)
+	}
+	return rule, nil
+}
+
+// MustGlob is like Glob but panics on a malformed pattern.
+func MustGlob(pattern string) Rule {
+	rule, err := Glob(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return rule
+}
+
+func (r *globRule) Match(relPath string, isDir bool) bool {
+	name := strings.Split(relPath, "/")
+	for _, pattern := range r.patterns {
+		if matchSegments(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// expandBraces turns "a.{go,md}" into "a.go" and "a.md".
+func expandBraces(pattern string) []string {
+	start := strings.IndexByte(pattern, '{')
+	if start < 0 {
+		return []string{pattern}
+	}
+	depth := 0
+	var alternatives []string
+	last := start + 1
+	for i := start; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				alternatives = append(alternatives, pattern[last:i])
+				last = i + 1
+			}
+		case '}':
+			depth--
+			if depth == 0 {
+				alternatives = append(alternatives, pattern[last:i])
+				var result []string
+				for _, alternative := range alternatives {
+					result = append(result, expandBraces(pattern[:start]+alternative+pattern[i+1:])...)
+				}
+				return result
+			}
+		}
+	}
+	// Unbalanced braces are taken literally.
+	return []string{pattern}
+}
+
+type regexpRule struct {
+	re *regexp.Regexp
+}
+
+// Regexp returns a rule matching the relative path against a regular
+// expression.
+func Regexp(expr string) (Rule, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &regexpRule{re: re}, nil
+}
+
+// MustRegexp is like Regexp but panics on a malformed expression.
+func MustRegexp(expr string) Rule {
+	rule, err := Regexp(expr)
+	if err != nil {
+		panic(err)
+	}
+	return rule
+}
+
+func (r *regexpRule) Match(relPath string, isDir bool) bool {
+	return r.re.MatchString(relPath)
+}
@@ -0,0 +1,161 @@
+package fs
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The benchmarks measure snapshots of a synthetic tree, for example:
+//
+//	go test ./fs -run '^$' -bench . -entries 1000000 -fanout 1000
+var (
+	benchEntries = flag.Int("entries", 100000, "number of files in the benchmark tree")
+	benchFanout  = flag.Int("fanout", 1000, "number of files per directory in the benchmark tree")
+)
+
+var (
+	benchOnce sync.Once
+	benchRoot string
+	benchLeaf string
+	benchErr  error
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	code := m.Run()
+	if benchRoot != "" {
+		os.RemoveAll(benchRoot)
+	}
+	os.Exit(code)
+}
+
+// benchTree generates the tree once for all benchmarks and returns its root
+// and the last file, which is the slowest to find in its directory.
+func benchTree(b *testing.B) (string, string) {
+	b.Helper()
+	benchOnce.Do(func() {
+		benchRoot, benchErr = os.MkdirTemp("", "rxfsnotify-bench")
+		if benchErr == nil {
+			benchLeaf, benchErr = generate(benchRoot, *benchEntries, *benchFanout)
+		}
+	})
+	if benchErr != nil {
+		b.Fatal(benchErr)
+	}
+	return benchRoot, benchLeaf
+}
+
+// generate spreads entries files over directories of fanout files each and
+// returns the path of the last one.
+func generate(root string, entries int, fanout int) (string, error) {
+	last := ""
+	for i := 0; i < entries; i++ {
+		sub := filepath.Join(root, fmt.Sprintf("d%06d", i/fanout))
+		if i%fanout == 0 {
+			if err := os.MkdirAll(sub, 0o755); err != nil {
+				return "", err
+			}
+		}
+		last = filepath.Join(sub, fmt.Sprintf("f%06d", i%fanout))
+		if err := os.WriteFile(last, nil, 0o644); err != nil {
+			return "", err
+		}
+	}
+	return last, nil
+}
+
+// touches gives every touch a new modification time, the benchmarks run
+// their loops several times starting from 0.
+var touches int64
+
+func touch(b *testing.B, path string) {
+	touches++
+	t := time.Unix(1600000000+touches, 0)
+	if err := os.Chtimes(path, t, t); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkBuild(b *testing.B) {
+	root, _ := benchTree(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewFileSystem(root); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Every iteration rewrites the same file, which has to be found among all
+// the children of its directory.
+func BenchmarkUpdate(b *testing.B) {
+	root, leaf := benchTree(b)
+	tree, err := NewFileSystem(root)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		touch(b, leaf)
+		if err := tree.Update(leaf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDeepCopy(b *testing.B) {
+	root, _ := benchTree(b)
+	tree, err := NewFileSystem(root)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.DeepCopy()
+	}
+}
+
+// A whole batch: update the current snapshot, diff it against the reported
+// one and sync them.
+func BenchmarkSync(b *testing.B) {
+	root, leaf := benchTree(b)
+	var snapshot Snapshot
+	if err := snapshot.Init(root); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		touch(b, leaf)
+		if err := snapshot.UpdateChangedDir(leaf); err != nil {
+			b.Fatal(err)
+		}
+		if diffs := snapshot.DiffAndSync(); len(diffs) != 1 {
+			b.Fatalf("got %d diffs, want 1", len(diffs))
+		}
+	}
+}
+
+// A full diff: the trees share no nodes, so every node is compared.
+func BenchmarkDiff(b *testing.B) {
+	root, leaf := benchTree(b)
+	tree, err := NewFileSystem(root)
+	if err != nil {
+		b.Fatal(err)
+	}
+	changed := tree.DeepCopy()
+	touch(b, leaf)
+	if err := changed.Update(leaf); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if diffs := tree.Diff(changed); len(diffs) != 1 {
+			b.Fatalf("got %d diffs, want 1", len(diffs))
+		}
+	}
+}
@@ -1,24 +1,87 @@
 package fs
 
-func (n *Node) deepCopy() *Node {
+import "sync/atomic"
+
+// generations hands out the generation of every tree, so a tree never
+// mistakes the nodes of another tree for its own.
+var generations atomic.Uint64
+
+func nextGeneration() uint64 {
+	return generations.Add(1)
+}
+
+func (n *Node) deepCopy(gen uint64) *Node {
 	newNode := &Node{
 		Name:     n.Name,
 		AbsPath:  n.AbsPath,
 		IsFile:   n.IsFile,
 		Size:     n.Size,
 		ModTime:  n.ModTime,
+		Mode:     n.Mode,
+		Dev:      n.Dev,
+		Ino:      n.Ino,
+		Hash:     n.Hash,
+		HashName: n.HashName,
+		Target:   n.Target,
 		Children: make([]*Node, len(n.Children)),
+		gen:      gen,
 	}
 
 	for i, child := range n.Children {
-		newNode.Children[i] = child.deepCopy()
+		newNode.Children[i] = child.deepCopy(gen)
 	}
 
 	return newNode
 }
 
 func (fs *FileSystem) DeepCopy() *FileSystem {
+	gen := nextGeneration()
 	return &FileSystem{
-		Root: fs.Root.deepCopy(),
+		Root: fs.Root.deepCopy(gen),
+		opts: fs.opts,
+		gen:  gen,
+	}
+}
+
+// fork returns a tree that shares every node with fs. Neither tree owns the
+// shared nodes any more, whichever modifies a path first copies the nodes on
+// it, so forking costs O(1) and every later update O(depth).
+func (fs *FileSystem) fork() *FileSystem {
+	fs.gen = nextGeneration()
+	return &FileSystem{
+		Root: fs.Root,
+		opts: fs.opts,
+		gen:  nextGeneration(),
+	}
+}
+
+// clone makes a shallow copy of n owned by generation gen. The children stay
+// shared, only the list holding them is copied.
+func (n *Node) clone(gen uint64) *Node {
+	newNode := *n
+	newNode.Children = append([]*Node(nil), n.Children...)
+	newNode.index = nil
+	newNode.gen = gen
+	return &newNode
+}
+
+func (fs *FileSystem) ownRoot() *Node {
+	if fs.Root.gen != fs.gen {
+		fs.Root = fs.Root.clone(fs.gen)
+	}
+	return fs.Root
+}
+
+// own returns a copy of child that fs may modify, replacing child in parent,
+// which fs must already own.
+func (fs *FileSystem) own(parent *Node, child *Node) *Node {
+	if child.gen == fs.gen {
+		return child
+	}
+	owned := child.clone(fs.gen)
+	parent.Children[parent.search(child.Name)] = owned
+	if parent.index != nil {
+		parent.index[child.Name] = owned
 	}
+	return owned
 }
@@ -0,0 +1,68 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestForkSharesUnchangedNodes(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a/x", "a/y", "b/z"} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("1"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base, err := NewFileSystem(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forked := base.fork()
+	if forked.Root != base.Root {
+		t.Fatal("a fork does not share the root")
+	}
+
+	x := filepath.Join(root, "a", "x")
+	if err := os.WriteFile(x, []byte("22"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := forked.Update(x); err != nil {
+		t.Fatal(err)
+	}
+
+	baseA, forkedA := base.Root.child("a"), forked.Root.child("a")
+	if forked.Root == base.Root || forkedA == baseA {
+		t.Error("nodes on the path to the change are still shared")
+	}
+	if forked.Root.child("b") != base.Root.child("b") {
+		t.Error("untouched directory b is not shared")
+	}
+	if forkedA.child("y") != baseA.child("y") {
+		t.Error("untouched file a/y is not shared")
+	}
+	if got := baseA.child("x").Size; got != 1 {
+		t.Errorf("the change leaked into the earlier tree: a/x has size %d", got)
+	}
+	if got := forkedA.child("x").Size; got != 2 {
+		t.Errorf("a/x has size %d in the updated tree, want 2", got)
+	}
+
+	// Updating the earlier tree copies the shared nodes as well.
+	y := filepath.Join(root, "a", "y")
+	if err := os.WriteFile(y, []byte("333"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := base.Update(y); err != nil {
+		t.Fatal(err)
+	}
+	if got := forked.Root.child("a").child("y").Size; got != 1 {
+		t.Errorf("the change leaked into the forked tree: a/y has size %d", got)
+	}
+	if forked.Root.child("b") != base.Root.child("b") {
+		t.Error("untouched directory b is no longer shared")
+	}
+}
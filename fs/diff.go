@@ -1,118 +1,156 @@
 package fs
 
 import (
+	"bytes"
 	"fmt"
+	"time"
 )
 
+// Diff describes one change between two trees. The attributes describe the
+// new node, or the old one when it was removed.
 type Diff struct {
 	AbsPath string
 	Path    string
-	Op      int
+	Op      Op
+	// OldAbsPath and OldPath are only set for Renamed.
+	OldAbsPath string
+	OldPath    string
+	IsDir      bool
+	Size       int64
+	ModTime    time.Time
+	// Target is where the node points when it is a symbolic link.
+	Target string
+
+	node *Node
+}
+
+func newDiff(op Op, path string, node *Node) Diff {
+	return Diff{
+		AbsPath: node.AbsPath,
+		Path:    path,
+		Op:      op,
+		IsDir:   !node.IsFile,
+		Size:    node.Size,
+		ModTime: node.ModTime,
+		Target:  node.Target,
+		node:    node,
+	}
+}
+
+// subtreeDiffs reports node and all of its descendants with the same op.
+func subtreeDiffs(op Op, node *Node, path string) []Diff {
+	diffs := []Diff{newDiff(op, path, node)}
+	for _, child := range node.Children {
+		diffs = append(diffs, subtreeDiffs(op, child, joinPath(path, child.Name))...)
+	}
+	return diffs
 }
 
 func diffNodes(oldNode *Node, newNode *Node, path string) []Diff {
 	var diffs []Diff
 
-	// If both nodes are null, there's no difference.
-	if oldNode == nil && newNode == nil {
+	// If both nodes are null, there's no difference. The same goes for a
+	// subtree shared by both trees: updates copy every node on the path to a
+	// change, so only dirty subtrees are ever walked.
+	if oldNode == newNode {
 		return diffs
 	}
 
-	// If one of the nodes is null, there's a difference.
-	if oldNode == nil || newNode == nil {
-		op := 1
-		absPath := ""
-		if oldNode != nil {
-			op = 0
-			absPath = oldNode.AbsPath
-		} else {
-			absPath = newNode.AbsPath
-		}
-		diffs = append(diffs, Diff{
-			AbsPath: absPath,
-			Path:    path,
-			Op:      op,
-		})
-		return diffs
+	// If one of the nodes is null, the whole subtree is a difference.
+	if oldNode == nil {
+		return subtreeDiffs(Created, newNode, path)
+	}
+	if newNode == nil {
+		return subtreeDiffs(Removed, oldNode, path)
 	}
 
-	// If both nodes exist, check their attributes.
-	if oldNode.IsFile != newNode.IsFile {
-		diffs = append(diffs, Diff{
-			AbsPath: newNode.AbsPath,
-			Path:    path,
-			Op:      2,
-		})
+	// If both nodes exist, check their attributes. Replacing a path with a
+	// link to the same kind of node changes its type as well.
+	if oldNode.IsFile != newNode.IsFile || oldNode.IsSymlink() != newNode.IsSymlink() {
+		diffs = append(diffs, newDiff(TypeChanged, path, newNode))
 		return diffs
 	}
 
-	// If both nodes exist and are directories, check their children.
-	oldChildren := make(map[string]*Node)
-	for _, child := range oldNode.Children {
-		oldChildren[child.Name] = child
+	if op, ok := changed(oldNode, newNode); ok {
+		diffs = append(diffs, newDiff(op, path, newNode))
 	}
 
-	newChildren := make(map[string]*Node)
-	for _, child := range newNode.Children {
-		newChildren[child.Name] = child
+	// If both nodes exist and are directories, check their children. Both
+	// lists are sorted by name, so a merge pairs them up in name order.
+	oldChildren, newChildren := oldNode.Children, newNode.Children
+	for len(oldChildren) > 0 || len(newChildren) > 0 {
+		switch {
+		case len(newChildren) == 0 || (len(oldChildren) > 0 && oldChildren[0].Name < newChildren[0].Name):
+			diffs = append(diffs, subtreeDiffs(Removed, oldChildren[0], joinPath(path, oldChildren[0].Name))...)
+			oldChildren = oldChildren[1:]
+		case len(oldChildren) == 0 || newChildren[0].Name < oldChildren[0].Name:
+			diffs = append(diffs, subtreeDiffs(Created, newChildren[0], joinPath(path, newChildren[0].Name))...)
+			newChildren = newChildren[1:]
+		default:
+			diffs = append(diffs, diffNodes(oldChildren[0], newChildren[0], joinPath(path, newChildren[0].Name))...)
+			oldChildren, newChildren = oldChildren[1:], newChildren[1:]
+		}
 	}
 
-	for name, oldChild := range oldChildren {
-		newChild, ok := newChildren[name]
-		if ok {
-			childPath := path
-			if path != "" {
-				childPath += "/"
-			}
-			childPath += name
-			childDiffs := diffNodes(oldChild, newChild, childPath)
-			diffs = append(diffs, childDiffs...)
-		} else {
-			diffs = append(diffs, Diff{
-				AbsPath: oldChild.AbsPath,
-				Path:    path + "/" + name,
-				Op:      0,
-			})
-			// Add this line to check the children of the deleted node
-			for _, grandChild := range oldChild.Children {
-				childDiffs := diffNodes(grandChild, nil, path+"/"+name+"/"+grandChild.Name)
-				diffs = append(diffs, childDiffs...)
-			}
-		}
+	return diffs
+}
+
+// joinPath joins a slash separated relative path and a child name.
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
 	}
+	return path + "/" + name
+}
 
-	for name, newChild := range newChildren {
-		_, ok := oldChildren[name]
-		if !ok {
-			diffs = append(diffs, Diff{
-				AbsPath: newChild.AbsPath,
-				Path:    path + "/" + name,
-				Op:      1,
-			})
-			// Add this line to check the children of the new node
-			for _, grandChild := range newChild.Children {
-				childDiffs := diffNodes(nil, grandChild, path+"/"+name+"/"+grandChild.Name)
-				diffs = append(diffs, childDiffs...)
-			}
+// changed compares two nodes of the same type. A directory's size and mtime
+// follow its children, so only its mode is compared. When both files were
+// hashed the hash decides, so a touch that only moved the mtime is no change.
+func changed(oldNode *Node, newNode *Node) (Op, bool) {
+	// Trees saved before link targets were recorded have none to compare.
+	if oldNode.Target != "" && newNode.Target != "" && oldNode.Target != newNode.Target {
+		return Retargeted, true
+	}
+	if oldNode.IsFile {
+		// Only trust hashes when both sides were hashed by the same algorithm.
+		hashed := oldNode.Hash != nil && newNode.Hash != nil &&
+			oldNode.HashName != "" && oldNode.HashName == newNode.HashName
+		switch {
+		case oldNode.Size != newNode.Size:
+			return Modified, true
+		case hashed && !bytes.Equal(oldNode.Hash, newNode.Hash):
+			return Modified, true
+		case !hashed && !oldNode.ModTime.Equal(newNode.ModTime):
+			return Modified, true
 		}
 	}
-
-	return diffs
+	if oldNode.Mode != newNode.Mode {
+		return AttribChanged, true
+	}
+	return 0, false
 }
 
 func (fs *FileSystem) Diff(fs2 *FileSystem) []Diff {
-	return diffNodes(fs.Root, fs2.Root, "")
+	return pairRenames(diffNodes(fs.Root, fs2.Root, ""))
 }
 
 func (d Diff) String() string {
 	opString := ""
 	switch d.Op {
-	case 0:
+	case Removed:
 		opString = "file/directory deleted"
-	case 1:
+	case Created:
 		opString = "new file/directory"
-	case 2:
+	case TypeChanged:
+		opString = "file/directory type changed"
+	case Modified:
 		opString = "file modified"
+	case Renamed:
+		opString = "file/directory renamed from " + d.OldPath
+	case AttribChanged:
+		opString = "file/directory attributes changed"
+	case Retargeted:
+		opString = "symbolic link retargeted to " + d.Target
 	}
 	return fmt.Sprintf("AbsPath: %s, Path: %s, Operation: %s", d.AbsPath, d.Path, opString)
 }
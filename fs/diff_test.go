@@ -0,0 +1,125 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChangeClassification(t *testing.T) {
+	write := func(content string) func(t *testing.T, p string) {
+		return func(t *testing.T, p string) {
+			if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tests := []struct {
+		name string
+		opts []Option
+		// dir makes a start as a directory instead of a file.
+		dir    bool
+		change func(t *testing.T, p string)
+		want   []Diff
+	}{
+		{
+			name:   "content change",
+			change: write("changed"),
+			want:   []Diff{{Op: Modified, Path: "a"}},
+		},
+		{
+			name: "rewrite keeping size and mtime",
+			opts: []Option{WithContentHash()},
+			change: func(t *testing.T, p string) {
+				info, err := os.Stat(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				write("tnetnoc")(t, p)
+				if err := os.Chtimes(p, info.ModTime(), info.ModTime()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []Diff{{Op: Modified, Path: "a"}},
+		},
+		{
+			name: "touch with content hash",
+			opts: []Option{WithContentHash()},
+			change: func(t *testing.T, p string) {
+				later := time.Now().Add(time.Hour)
+				if err := os.Chtimes(p, later, later); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "chmod only",
+			change: func(t *testing.T, p string) {
+				if err := os.Chmod(p, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []Diff{{Op: AttribChanged, Path: "a"}},
+		},
+		{
+			name: "file to directory",
+			change: func(t *testing.T, p string) {
+				if err := os.Remove(p); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Mkdir(p, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []Diff{{Op: TypeChanged, Path: "a"}},
+		},
+		{
+			name: "file to symbolic link",
+			change: func(t *testing.T, p string) {
+				if err := os.Remove(p); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink("b", p); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []Diff{{Op: TypeChanged, Path: "a"}},
+		},
+		{
+			name: "directory to file",
+			dir:  true,
+			change: func(t *testing.T, p string) {
+				if err := os.Remove(p); err != nil {
+					t.Fatal(err)
+				}
+				write("content")(t, p)
+			},
+			want: []Diff{{Op: TypeChanged, Path: "a"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			p := filepath.Join(root, "a")
+			write("content")(t, filepath.Join(root, "b"))
+			if tt.dir {
+				if err := os.Mkdir(p, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				write("content")(t, p)
+			}
+			before, err := NewFileSystem(root, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.change(t, p)
+			after, err := NewFileSystem(root, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkDiffs(t, before.Diff(after), tt.want)
+		})
+	}
+}
@@ -4,38 +4,129 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
 
 type Node struct {
-	Name     string
-	AbsPath  string
+	Name    string
+	AbsPath string
+	// Children are kept sorted by name, so trees and their diffs always come
+	// out in the same order.
 	Children []*Node
 	IsFile   bool
 	Size     int64
 	ModTime  time.Time
+	Mode     os.FileMode
+	// Dev and Ino identify the node across renames, both are zero on
+	// platforms without inodes.
+	Dev uint64
+	Ino uint64
+	// Hash is the content hash of a regular file, only set when the
+	// FileSystem is built WithContentHash or WithHasher.
+	Hash []byte
+	// HashName is the Name of the Hasher that computed Hash. Trees saved
+	// before it was recorded have none, their hashes are never compared.
+	HashName string
+	// Target is where a symbolic link points, as stored in the link. A link
+	// is a file unless the FileSystem is built WithFollowSymlinks, then it
+	// takes the attributes and children of its target.
+	Target string
+
+	// index maps names to Children. It is built when the node is first
+	// modified, until then lookups binary search Children.
+	index map[string]*Node
+	// gen is the generation of the tree that created the node. Only that
+	// tree may modify it, every other tree sharing it copies it first.
+	gen uint64
 }
 
 type FileSystem struct {
 	Root *Node
+	opts options
+	gen  uint64
 }
 
 func newNode(name string, absPath string, isFile bool) *Node {
 	return &Node{Name: name, AbsPath: absPath, IsFile: isFile}
 }
 
+// IsSymlink reports whether the node is a symbolic link.
+func (n *Node) IsSymlink() bool {
+	return n.Mode&os.ModeSymlink != 0
+}
+
 func (n *Node) addChild(name string, absPath string, isFile bool) *Node {
 	newNode := newNode(name, absPath, isFile)
-	n.Children = append(n.Children, newNode)
+	newNode.gen = n.gen
+	// Walks visit entries in lexical order, so appending is the common case.
+	i := len(n.Children)
+	if i > 0 && n.Children[i-1].Name > name {
+		i = sort.Search(len(n.Children), func(j int) bool { return n.Children[j].Name >= name })
+	}
+	n.Children = append(n.Children, nil)
+	copy(n.Children[i+1:], n.Children[i:])
+	n.Children[i] = newNode
+	n.buildIndex()
+	n.index[name] = newNode
 	return newNode
 }
 
-func NewFileSystem(rootAbsPath string) (*FileSystem, error) {
+// child never modifies n, shared nodes may be read by several trees at once.
+func (n *Node) child(name string) *Node {
+	if n.index != nil {
+		return n.index[name]
+	}
+	if i := n.search(name); i < len(n.Children) && n.Children[i].Name == name {
+		return n.Children[i]
+	}
+	return nil
+}
+
+func (n *Node) search(name string) int {
+	return sort.Search(len(n.Children), func(j int) bool { return n.Children[j].Name >= name })
+}
+
+func (n *Node) buildIndex() {
+	if n.index != nil {
+		return
+	}
+	n.index = make(map[string]*Node, len(n.Children))
+	for _, child := range n.Children {
+		n.index[child.Name] = child
+	}
+}
+
+func (n *Node) removeChild(name string) {
+	if n.child(name) == nil {
+		return
+	}
+	n.buildIndex()
+	delete(n.index, name)
+	i := n.search(name)
+	n.Children = append(n.Children[:i], n.Children[i+1:]...)
+}
+
+// sortChildren restores the ordering of a tree that was not built by this
+// package, e.g. one decoded from an older state file.
+func (n *Node) sortChildren() {
+	if !sort.SliceIsSorted(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name }) {
+		sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+	}
+	for _, child := range n.Children {
+		child.sortChildren()
+	}
+}
+
+func NewFileSystem(rootAbsPath string, opts ...Option) (*FileSystem, error) {
 
 	instance := FileSystem{
 		Root: newNode(string(os.PathSeparator), rootAbsPath, false),
+		opts: newOptions(opts),
+		gen:  nextGeneration(),
 	}
+	instance.Root.gen = instance.gen
 	err := instance.build(rootAbsPath)
 	if err != nil {
 		return nil, err
@@ -44,6 +135,48 @@ func NewFileSystem(rootAbsPath string) (*FileSystem, error) {
 	return &instance, nil
 }
 
+// Lstat returns the node at absPath without its children, or nil when the
+// path does not exist. A symbolic link is described by itself.
+func Lstat(absPath string) (*Node, error) {
+	info, err := os.Lstat(absPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fs := FileSystem{}
+	n := newNode(filepath.Base(absPath), absPath, !info.IsDir())
+	fs.fill(n, info, info)
+	return n, nil
+}
+
+// Stat returns the node at absPath, with the whole subtree when it is a
+// directory, or nil when the path does not exist.
+func Stat(absPath string) (*Node, error) {
+	info, err := os.Lstat(absPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(absPath)
+	if info.IsDir() {
+		tree, err := NewFileSystem(absPath)
+		if err != nil {
+			return nil, err
+		}
+		tree.Root.Name = name
+		return tree.Root, nil
+	}
+	fs := FileSystem{}
+	n := newNode(name, absPath, true)
+	fs.fill(n, info, info)
+	return n, nil
+}
+
 func (fs *FileSystem) Update(innerAbsPath string) error {
 	if !strings.HasPrefix(innerAbsPath, fs.Root.AbsPath) {
 		return fmt.Errorf("path %s is not a subpath of the root path %s", innerAbsPath, fs.Root.AbsPath)
@@ -54,96 +187,194 @@ func (fs *FileSystem) Update(innerAbsPath string) error {
 		return err
 	}
 
-	// Remove old node.
-	parentNode := fs.Root
+	// The root itself changed, rebuild everything.
+	if innerPath == "." {
+		root := fs.ownRoot()
+		root.Children, root.index = nil, nil
+		return fs.build(fs.Root.AbsPath)
+	}
+
+	// Remove old node, copying every shared node on the way down.
+	parentNode := fs.ownRoot()
 	parts := strings.Split(innerPath, string(os.PathSeparator))
 	for i, part := range parts {
 		if i == len(parts)-1 {
 			// Remove the last part from its parent node.
-			for j, child := range parentNode.Children {
-				if child.Name == part {
-					parentNode.Children = append(parentNode.Children[:j], parentNode.Children[j+1:]...)
-					break
-				}
-			}
+			parentNode.removeChild(part)
 		} else {
 			// Find the next parent node.
-			found := false
-			for _, child := range parentNode.Children {
-				if child.Name == part {
-					parentNode = child
-					found = true
-					break
-				}
-			}
-			if !found {
+			next := parentNode.child(part)
+			if next == nil {
 				return fmt.Errorf("part %s not found in path %s", part, innerPath)
 			}
+			if next.IsFile {
+				// The path lies behind a link the tree does not follow.
+				return nil
+			}
+			parentNode = fs.own(parentNode, next)
 		}
 	}
 
-	// Build new node.
-	return filepath.Walk(innerAbsPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		isFile := !info.IsDir()
-		size := info.Size()
-		modTime := info.ModTime()
+	// Adding or removing an entry moves the mtime of its directory, which
+	// gets no event of its own.
+	if info, err := os.Lstat(parentNode.AbsPath); err == nil {
+		resolved, _ := fs.resolve(parentNode.AbsPath, info)
+		fs.fill(parentNode, info, resolved)
+	}
 
-		relPath, err := filepath.Rel(fs.Root.AbsPath, path)
-		if err != nil {
-			return err
+	// The path is gone or filtered out, removing the old node is all there
+	// is to do.
+	info, err := os.Lstat(innerAbsPath)
+	if os.IsNotExist(err) {
+		if fs.opts.hashes != nil {
+			fs.opts.hashes.forget(innerAbsPath)
 		}
-
-		fs.add(relPath, isFile, size, modTime)
-
 		return nil
-	})
+	}
+	if err == nil {
+		if _, isDir := fs.resolve(innerAbsPath, info); fs.opts.excluded(fs.Root.AbsPath, innerAbsPath, isDir) {
+			return nil
+		}
+	}
+
+	// Build new node.
+	return fs.walk(innerAbsPath)
 }
 
 func (fs *FileSystem) build(rootPath string) error {
 	fs.Root.AbsPath = rootPath
-	return filepath.Walk(fs.Root.AbsPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	return fs.walk(fs.Root.AbsPath)
+}
+
+// walk adds absPath and everything below it to the tree, in lexical order.
+func (fs *FileSystem) walk(absPath string) error {
+	info, err := os.Lstat(absPath)
+	if err != nil {
+		return err
+	}
+	return fs.walkPath(absPath, info, fs.ancestors(absPath))
+}
 
-		isFile := !info.IsDir()
-		size := info.Size()
-		modTime := info.ModTime()
+// walkPath adds one path and descends into it when it is a directory.
+// ancestors are the directories above it, a followed link that leads back to
+// one of them is added without its children, which would repeat forever.
+func (fs *FileSystem) walkPath(absPath string, info os.FileInfo, ancestors []inodeKey) error {
+	resolved, isDir := fs.resolve(absPath, info)
+	if fs.opts.excluded(fs.Root.AbsPath, absPath, isDir) {
+		return nil
+	}
 
-		relPath, err := filepath.Rel(fs.Root.AbsPath, path)
+	relPath, err := filepath.Rel(fs.Root.AbsPath, absPath)
+	if err != nil {
+		return err
+	}
+	fs.add(relPath, info, resolved)
+	if !isDir {
+		return nil
+	}
+
+	if fs.opts.followSymlinks {
+		dev, ino := fileID(resolved)
+		key := inodeKey{dev: dev, ino: ino}
+		for _, ancestor := range ancestors {
+			if ancestor == key && ino != 0 {
+				return nil
+			}
+		}
+		ancestors = append(ancestors[:len(ancestors):len(ancestors)], key)
+	}
+
+	entries, err := os.ReadDir(absPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		childInfo, err := entry.Info()
+		if os.IsNotExist(err) {
+			// Removed since it was listed, its own event will follow.
+			continue
+		}
 		if err != nil {
 			return err
 		}
+		if err := fs.walkPath(filepath.Join(absPath, entry.Name()), childInfo, ancestors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		fs.add(relPath, isFile, size, modTime)
+// resolve returns the attributes a path has in the tree and whether it is a
+// directory. Links are followed WithFollowSymlinks, and the root always is.
+// A dangling link stays a link.
+func (fs *FileSystem) resolve(absPath string, info os.FileInfo) (os.FileInfo, bool) {
+	if info.Mode()&os.ModeSymlink != 0 && (fs.opts.followSymlinks || absPath == fs.Root.AbsPath) {
+		if target, err := os.Stat(absPath); err == nil {
+			return target, target.IsDir()
+		}
+	}
+	return info, info.IsDir()
+}
 
+// ancestors returns the directories above absPath up to the root, only
+// needed for cycle detection when links are followed.
+func (fs *FileSystem) ancestors(absPath string) []inodeKey {
+	if !fs.opts.followSymlinks {
 		return nil
-	})
+	}
+	var keys []inodeKey
+	for p := absPath; p != fs.Root.AbsPath; {
+		parent := filepath.Dir(p)
+		if parent == p {
+			break
+		}
+		p = parent
+		if info, err := os.Stat(p); err == nil {
+			dev, ino := fileID(info)
+			keys = append(keys, inodeKey{dev: dev, ino: ino})
+		}
+	}
+	return keys
 }
 
-func (fs *FileSystem) add(path string, isFile bool, size int64, modTime time.Time) {
+// add puts a node for path into the tree. info describes the path itself,
+// resolved what it is in the tree, which differs for a followed link.
+func (fs *FileSystem) add(path string, info os.FileInfo, resolved os.FileInfo) {
+	if path == "." {
+		fs.fill(fs.ownRoot(), info, resolved)
+		return
+	}
+
 	parts := strings.Split(path, string(os.PathSeparator))
-	currentNode := fs.Root
+	currentNode := fs.ownRoot()
 
 	for _, part := range parts {
-		found := false
-		for _, child := range currentNode.Children {
-			if child.Name == part {
-				currentNode = child
-				found = true
-				break
-			}
+		if next := currentNode.child(part); next != nil {
+			currentNode = fs.own(currentNode, next)
+			continue
 		}
+		absPath := filepath.Join(currentNode.AbsPath, part)
+		currentNode = currentNode.addChild(part, absPath, !resolved.IsDir())
+		fs.fill(currentNode, info, resolved)
+	}
+}
 
-		if !found {
-			absPath := filepath.Join(currentNode.AbsPath, part)
-			currentNode = currentNode.addChild(part, absPath, isFile)
-			currentNode.Size = size
-			currentNode.ModTime = modTime
+// fill copies the attributes of resolved into n. The identity comes from
+// info, so that a followed link is paired by its own inode when renamed.
+func (fs *FileSystem) fill(n *Node, info os.FileInfo, resolved os.FileInfo) {
+	n.Size = resolved.Size()
+	n.ModTime = resolved.ModTime()
+	n.Mode = resolved.Mode() | info.Mode()&os.ModeSymlink
+	n.Dev, n.Ino = fileID(info)
+	n.Target = ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		n.Target, _ = os.Readlink(n.AbsPath)
+	}
+	n.Hash, n.HashName = nil, ""
+	if fs.opts.hashes != nil && resolved.Mode().IsRegular() {
+		n.Hash = fs.opts.hashes.sum(n)
+		if n.Hash != nil {
+			n.HashName = fs.opts.hashes.hasher.Name()
 		}
 	}
 }
@@ -155,6 +386,9 @@ func (n *Node) Print(prefix string) {
 	} else {
 		nodeType = "Directory"
 	}
+	if n.IsSymlink() {
+		nodeType += " -> " + n.Target
+	}
 	fmt.Printf("%s%s (%s)(%d)(%s)(%s)\n", prefix, n.Name, nodeType, n.Size, n.ModTime.Format("2006-01-02 15:04:05"), n.AbsPath)
 	for _, child := range n.Children {
 		child.Print(prefix + "  ")
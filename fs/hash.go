@@ -0,0 +1,129 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Hasher computes content hashes. Name identifies the algorithm and is
+// stored with every hash, hashes are only compared when their names match.
+// After switching hashers, or against a state file written with another
+// one, files are compared by modification time until they are hashed again.
+type Hasher interface {
+	Name() string
+	New() hash.Hash
+}
+
+type hasher struct {
+	name    string
+	newHash func() hash.Hash
+}
+
+func (h hasher) Name() string   { return h.name }
+func (h hasher) New() hash.Hash { return h.newHash() }
+
+// NewHasher adapts any hash.Hash constructor, e.g. a BLAKE3 implementation.
+func NewHasher(name string, newHash func() hash.Hash) Hasher {
+	return hasher{name: name, newHash: newHash}
+}
+
+// SHA256 is the default hasher of WithContentHash.
+func SHA256() Hasher {
+	return NewHasher("sha256", sha256.New)
+}
+
+// XXHash64 is a fast non-cryptographic hasher, good enough to tell edits
+// from touches.
+func XXHash64() Hasher {
+	return NewHasher("xxh64", func() hash.Hash { return newXXH64() })
+}
+
+// HashFile returns the SHA-256 of the file contents.
+func HashFile(absPath string) ([]byte, error) {
+	return hashFileWith(SHA256(), absPath)
+}
+
+func hashFileWith(h Hasher, absPath string) ([]byte, error) {
+	f, err := os.Open(absPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sum := h.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return nil, err
+	}
+	return sum.Sum(nil), nil
+}
+
+// racyWindow is how close to the time it was hashed a file may have been
+// modified before its cached hash is distrusted. A rewrite within the same
+// mtime tick leaves size and mtime unchanged, so such files are always
+// hashed again.
+const racyWindow = 2 * time.Second
+
+type hashEntry struct {
+	dev, ino uint64
+	size     int64
+	modTime  time.Time
+	hashedAt time.Time
+	sum      []byte
+}
+
+// hashCache remembers the hash of every file by path, valid as long as the
+// device, inode, size and mtime are unchanged. It is shared by all trees
+// built with the same options, so rebuilding a tree only reads the files
+// that changed.
+type hashCache struct {
+	hasher  Hasher
+	mu      sync.Mutex
+	entries map[string]hashEntry
+}
+
+func newHashCache(h Hasher) *hashCache {
+	return &hashCache{hasher: h, entries: make(map[string]hashEntry)}
+}
+
+// sum returns the hash of the file n describes, or nil when it cannot be read.
+func (c *hashCache) sum(n *Node) []byte {
+	c.mu.Lock()
+	entry, ok := c.entries[n.AbsPath]
+	c.mu.Unlock()
+	if ok && entry.dev == n.Dev && entry.ino == n.Ino && entry.size == n.Size &&
+		entry.modTime.Equal(n.ModTime) && entry.modTime.Before(entry.hashedAt.Add(-racyWindow)) {
+		return entry.sum
+	}
+
+	hashedAt := time.Now()
+	sum, err := hashFileWith(c.hasher, n.AbsPath)
+	if err != nil {
+		// A file that vanished or cannot be read simply has no hash.
+		return nil
+	}
+	c.mu.Lock()
+	c.entries[n.AbsPath] = hashEntry{
+		dev: n.Dev, ino: n.Ino, size: n.Size, modTime: n.ModTime,
+		hashedAt: hashedAt, sum: sum,
+	}
+	c.mu.Unlock()
+	return sum
+}
+
+// forget drops the entries of a removed path and everything below it.
+func (c *hashCache) forget(absPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, absPath)
+	prefix := absPath + string(os.PathSeparator)
+	for p := range c.entries {
+		if len(p) > len(prefix) && p[:len(prefix)] == prefix {
+			delete(c.entries, p)
+		}
+	}
+}
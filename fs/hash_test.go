@@ -0,0 +1,71 @@
+package fs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// salted has the length of SHA-256 but different digests.
+func salted() Hasher {
+	return NewHasher("salted", func() hash.Hash {
+		h := sha256.New()
+		h.Write([]byte("salt"))
+		return h
+	})
+}
+
+func TestHashesOfDifferentHashers(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "a")
+	if err := os.WriteFile(file, []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := NewFileSystem(root, WithHasher(SHA256()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Through a state file, as after a restart.
+	var buf bytes.Buffer
+	if err := Encode(&buf, before); err != nil {
+		t.Fatal(err)
+	}
+	trees, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := trees[0]
+
+	tests := []struct {
+		name   string
+		hasher Hasher
+		touch  bool
+		want   []Diff
+	}{
+		{name: "same hasher", hasher: SHA256()},
+		{name: "same hasher after a touch", hasher: SHA256(), touch: true},
+		{name: "other hasher", hasher: salted()},
+		{name: "other hasher after a touch", hasher: salted(), touch: true, want: []Diff{{Op: Modified, Path: "a"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modTime := before.Root.Children[0].ModTime
+			if tt.touch {
+				modTime = modTime.Add(time.Hour)
+			}
+			if err := os.Chtimes(file, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+			after, err := NewFileSystem(root, WithHasher(tt.hasher))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkDiffs(t, before.Diff(after), tt.want)
+			checkDiffs(t, saved.Diff(after), tt.want)
+		})
+	}
+}
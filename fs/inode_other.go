@@ -0,0 +1,13 @@
+//go:build !unix
+
+package fs
+
+import (
+	"os"
+)
+
+// fileID returns zeros where os.FileInfo carries no inode, which disables
+// rename pairing.
+func fileID(info os.FileInfo) (dev uint64, ino uint64) {
+	return 0, 0
+}
@@ -0,0 +1,42 @@
+package fs
+
+// Op is the kind of change a Diff describes. The numeric values of Removed,
+// Created and TypeChanged match the plain ints Diff.Op used to carry.
+type Op int
+
+const (
+	// Removed means the path no longer exists.
+	Removed Op = iota
+	// Created means the path is new.
+	Created
+	// TypeChanged means the path switched between file and directory.
+	TypeChanged
+	// Modified means the content of a file changed.
+	Modified
+	// Renamed means the path was moved from OldPath.
+	Renamed
+	// AttribChanged means only the permission bits changed.
+	AttribChanged
+	// Retargeted means a symbolic link now points somewhere else.
+	Retargeted
+)
+
+func (op Op) String() string {
+	switch op {
+	case Removed:
+		return "REMOVED"
+	case Created:
+		return "CREATED"
+	case TypeChanged:
+		return "TYPE_CHANGED"
+	case Modified:
+		return "MODIFIED"
+	case Renamed:
+		return "RENAMED"
+	case AttribChanged:
+		return "ATTRIB_CHANGED"
+	case Retargeted:
+		return "RETARGETED"
+	}
+	return "UNKNOWN"
+}
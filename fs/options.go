@@ -0,0 +1,62 @@
+package fs
+
+// Option configures how a FileSystem is built.
+type Option func(*options)
+
+type options struct {
+	hashes         *hashCache
+	filter         Filter
+	followSymlinks bool
+}
+
+// Filter decides which paths are part of a tree. An excluded directory is
+// skipped together with everything below it.
+type Filter interface {
+	Excluded(root string, absPath string, isDir bool) bool
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithContentHash records a SHA-256 of every regular file so that Diff can
+// detect rewrites that keep the same size and modification time, and ignore
+// touches that change nothing but the modification time.
+func WithContentHash() Option {
+	return WithHasher(SHA256())
+}
+
+// WithHasher is WithContentHash with another hash algorithm. Hashes are
+// computed while building and updating the tree and cached, a file is only
+// read again when its inode, size or modification time changed.
+func WithHasher(h Hasher) Option {
+	cache := newHashCache(h)
+	return func(o *options) {
+		o.hashes = cache
+	}
+}
+
+// WithFollowSymlinks descends into symbolic links to directories and records
+// links to files with the attributes of their target. A link that leads back
+// to a directory above it, compared by device and inode, is recorded without
+// its children.
+func WithFollowSymlinks() Option {
+	return func(o *options) {
+		o.followSymlinks = true
+	}
+}
+
+// WithFilter leaves the paths excluded by f out of the tree.
+func WithFilter(f Filter) Option {
+	return func(o *options) {
+		o.filter = f
+	}
+}
+
+func (o options) excluded(root string, absPath string, isDir bool) bool {
+	return o.filter != nil && absPath != root && o.filter.Excluded(root, absPath, isDir)
+}
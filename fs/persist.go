@@ -0,0 +1,104 @@
+package fs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// stateMagic starts every state file, stateVersion is bumped whenever the
+// encoded layout of Node changes.
+const (
+	stateMagic   = "RXFS"
+	stateVersion = uint32(1)
+)
+
+var ErrBadState = errors.New("fs: not a snapshot state file")
+
+type persistedState struct {
+	Trees []*Node
+}
+
+// Encode writes trees to w in the versioned state format.
+func Encode(w io.Writer, trees ...*FileSystem) error {
+	if _, err := io.WriteString(w, stateMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, stateVersion); err != nil {
+		return err
+	}
+	state := persistedState{}
+	for _, tree := range trees {
+		state.Trees = append(state.Trees, tree.Root)
+	}
+	return gob.NewEncoder(w).Encode(&state)
+}
+
+// Decode reads trees written by Encode.
+func Decode(r io.Reader) ([]*FileSystem, error) {
+	magic := make([]byte, len(stateMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != stateMagic {
+		return nil, ErrBadState
+	}
+	var version uint32
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, ErrBadState
+	}
+	if version != stateVersion {
+		return nil, fmt.Errorf("fs: unsupported state version %d", version)
+	}
+
+	var state persistedState
+	if err := gob.NewDecoder(r).Decode(&state); err != nil {
+		return nil, err
+	}
+	var trees []*FileSystem
+	for _, root := range state.Trees {
+		if root == nil {
+			continue
+		}
+		root.sortChildren()
+		trees = append(trees, &FileSystem{Root: root})
+	}
+	return trees, nil
+}
+
+// SaveFile atomically replaces path with the encoded trees.
+func SaveFile(path string, trees ...*FileSystem) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	bw := bufio.NewWriter(tmp)
+	err = Encode(bw, trees...)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadFile reads trees saved by SaveFile.
+func LoadFile(path string) ([]*FileSystem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Decode(bufio.NewReader(f))
+}
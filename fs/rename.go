@@ -0,0 +1,149 @@
+package fs
+
+import (
+	"sort"
+	"strings"
+)
+
+type inodeKey struct {
+	dev uint64
+	ino uint64
+}
+
+func nodeKey(n *Node) (inodeKey, bool) {
+	if n == nil || n.Ino == 0 {
+		return inodeKey{}, false
+	}
+	return inodeKey{dev: n.Dev, ino: n.Ino}, true
+}
+
+// isUnder reports whether the slash separated path p lies below dir.
+func isUnder(p string, dir string) bool {
+	return dir == "" || strings.HasPrefix(p, dir+"/")
+}
+
+// pairRenames merges a Removed and a Created diff that share an inode into a
+// single Renamed diff. When a directory moved, the diffs of everything below
+// it are replaced by a diff between the old and the new directory, so only
+// what actually changed inside the moved directory is reported.
+//
+// Inodes are reused quickly after a delete, so a file is only paired when
+// its size and mtime are untouched, which a plain rename preserves, and a
+// directory when its mtime is untouched or it kept one of its entries.
+func pairRenames(diffs []Diff) []Diff {
+	removed := make(map[inodeKey]int)
+	var created []int
+	for i, d := range diffs {
+		key, ok := nodeKey(d.node)
+		if !ok {
+			continue
+		}
+		switch d.Op {
+		case Removed:
+			removed[key] = i
+		case Created:
+			created = append(created, i)
+		}
+	}
+	if len(removed) == 0 || len(created) == 0 {
+		return diffs
+	}
+
+	// Pair parents before their children so that a moved directory swallows
+	// its subtree instead of producing one rename per entry.
+	sort.SliceStable(created, func(a, b int) bool {
+		return strings.Count(diffs[created[a]].Path, "/") < strings.Count(diffs[created[b]].Path, "/")
+	})
+
+	consumed := make([]bool, len(diffs))
+	replaced := make(map[int][]Diff)
+	for _, i := range created {
+		if consumed[i] {
+			continue
+		}
+		to := diffs[i]
+		key, _ := nodeKey(to.node)
+		j, ok := removed[key]
+		if !ok || consumed[j] || !samePayload(diffs[j].node, to.node) {
+			continue
+		}
+		from := diffs[j]
+		consumed[i], consumed[j] = true, true
+
+		rename := to
+		rename.Op = Renamed
+		rename.OldAbsPath = from.AbsPath
+		rename.OldPath = from.Path
+		result := []Diff{rename}
+
+		if op, ok := changed(from.node, to.node); ok {
+			result = append(result, newDiff(op, to.Path, to.node))
+		}
+		if !to.node.IsFile {
+			for k, d := range diffs {
+				if (d.Op == Created && isUnder(d.Path, to.Path)) || (d.Op == Removed && isUnder(d.Path, from.Path)) {
+					consumed[k] = true
+				}
+			}
+			result = append(result, movedDirDiffs(from, to)...)
+		}
+		replaced[i] = result
+	}
+
+	var result []Diff
+	for i, d := range diffs {
+		if r, ok := replaced[i]; ok {
+			result = append(result, r...)
+			continue
+		}
+		if !consumed[i] {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+func samePayload(from *Node, to *Node) bool {
+	if from.IsFile != to.IsFile {
+		return false
+	}
+	if from.IsFile {
+		return from.Size == to.Size && from.ModTime.Equal(to.ModTime)
+	}
+	// The inode of a deleted directory is handed to the next mkdir, so a
+	// directory also needs its mtime untouched, or, since the mtime follows
+	// the entries, at least one entry it took along.
+	if from.ModTime.Equal(to.ModTime) {
+		return true
+	}
+	for _, child := range from.Children {
+		fromKey, ok := nodeKey(child)
+		if !ok {
+			continue
+		}
+		if toKey, ok := nodeKey(to.child(child.Name)); ok && toKey == fromKey {
+			return true
+		}
+	}
+	return false
+}
+
+// movedDirDiffs reports the changes inside a moved directory. Entries removed
+// from it are reported at their old location.
+func movedDirDiffs(from Diff, to Diff) []Diff {
+	var inner []Diff
+	for _, child := range from.node.Children {
+		inner = append(inner, diffNodes(child, to.node.child(child.Name), joinPath(to.Path, child.Name))...)
+	}
+	for _, child := range to.node.Children {
+		if from.node.child(child.Name) == nil {
+			inner = append(inner, subtreeDiffs(Created, child, joinPath(to.Path, child.Name))...)
+		}
+	}
+	for i, d := range inner {
+		if d.Op == Removed {
+			inner[i].Path = from.Path + strings.TrimPrefix(d.Path, to.Path)
+		}
+	}
+	return pairRenames(inner)
+}
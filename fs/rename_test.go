@@ -0,0 +1,74 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRenamedDirectory(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "x"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := NewFileSystem(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(filepath.Join(root, "a"), filepath.Join(root, "b")); err != nil {
+		t.Fatal(err)
+	}
+	// A new entry moves the directory's mtime, the old entry still proves the move.
+	if err := os.WriteFile(filepath.Join(root, "b", "y"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	after, err := NewFileSystem(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Diff{
+		{Op: Renamed, Path: "b", OldPath: "a"},
+		{Op: Created, Path: "b/y"},
+	}
+	checkDiffs(t, before.Diff(after), want)
+}
+
+// rm -r a && mkdir b usually hands a's inode to b, that is no rename.
+func TestReusedDirectoryInode(t *testing.T) {
+	t0 := time.Unix(1600000000, 0)
+	before := &FileSystem{Root: &Node{Children: []*Node{
+		{Name: "a", Dev: 1, Ino: 7, ModTime: t0, Children: []*Node{
+			{Name: "x", IsFile: true, Dev: 1, Ino: 8, ModTime: t0},
+		}},
+	}}}
+	after := &FileSystem{Root: &Node{Children: []*Node{
+		{Name: "b", Dev: 1, Ino: 7, ModTime: t0.Add(time.Second)},
+	}}}
+
+	want := []Diff{
+		{Op: Removed, Path: "a"},
+		{Op: Removed, Path: "a/x"},
+		{Op: Created, Path: "b"},
+	}
+	checkDiffs(t, before.Diff(after), want)
+}
+
+// checkDiffs compares the op and paths of diffs in order.
+func checkDiffs(t *testing.T, got []Diff, want []Diff) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d diffs %v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if got[i].Op != want[i].Op || got[i].Path != want[i].Path || got[i].OldPath != want[i].OldPath {
+			t.Errorf("diff %d = %v %s (from %q), want %v %s (from %q)",
+				i, got[i].Op, got[i].Path, got[i].OldPath, want[i].Op, want[i].Path, want[i].OldPath)
+		}
+	}
+}
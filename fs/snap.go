@@ -1,26 +1,30 @@
 package fs
 
 import (
+	"os"
 	"sync"
 )
 
+// Snapshot keeps the tree as of the last DiffAndSync and the current one.
+// Both share every node that did not change since, so syncing them, diffing
+// them and handing out copies only costs as much as the changes.
 type Snapshot struct {
 	oldSnapshot *FileSystem
 	curSnapshot *FileSystem
 	rwLocker    sync.RWMutex
 }
 
-func (fss *Snapshot) Init(rootDirPath string) error {
+func (fss *Snapshot) Init(rootDirPath string, opts ...Option) error {
 
 	fss.rwLocker.Lock()
 	defer fss.rwLocker.Unlock()
 
-	tempFs, err := NewFileSystem(rootDirPath)
+	tempFs, err := NewFileSystem(rootDirPath, opts...)
 	if err != nil {
 		return err
 	}
 	fss.oldSnapshot = tempFs
-	fss.curSnapshot = fss.oldSnapshot.DeepCopy()
+	fss.curSnapshot = fss.oldSnapshot.fork()
 	return nil
 }
 
@@ -40,8 +44,62 @@ func (fss *Snapshot) DiffAndSync() []Diff {
 	defer fss.rwLocker.Unlock()
 
 	diffs := fss.oldSnapshot.Diff(fss.curSnapshot)
-	if len(diffs) > 0 {
-		fss.oldSnapshot = fss.curSnapshot.DeepCopy()
+	// Sync even without diffs, otherwise the paths updated since stay dirty
+	// and are diffed again next time.
+	if fss.curSnapshot.Root != fss.oldSnapshot.Root {
+		fss.oldSnapshot = fss.curSnapshot
+		fss.curSnapshot = fss.oldSnapshot.fork()
 	}
 	return diffs
 }
+
+// Rescan rebuilds the current tree from disk with the options it was built
+// with, for when change notifications were lost. The next DiffAndSync reports
+// everything that differs from the synced tree.
+func (fss *Snapshot) Rescan() error {
+	fss.rwLocker.Lock()
+	defer fss.rwLocker.Unlock()
+
+	tree := &FileSystem{
+		Root: newNode(string(os.PathSeparator), fss.curSnapshot.Root.AbsPath, false),
+		opts: fss.curSnapshot.opts,
+		gen:  nextGeneration(),
+	}
+	tree.Root.gen = tree.gen
+	if err := tree.build(tree.Root.AbsPath); err != nil {
+		return err
+	}
+	fss.curSnapshot = tree
+	return nil
+}
+
+// Restore rebuilds the tree of rootDirPath and returns what changed since
+// previous, which is usually a tree loaded from a state file. The rebuilt
+// tree becomes the synced state.
+func (fss *Snapshot) Restore(previous *FileSystem, rootDirPath string, opts ...Option) ([]Diff, error) {
+	fss.rwLocker.Lock()
+	defer fss.rwLocker.Unlock()
+
+	tempFs, err := NewFileSystem(rootDirPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	diffs := previous.Diff(tempFs)
+	fss.oldSnapshot = tempFs
+	fss.curSnapshot = fss.oldSnapshot.fork()
+	return diffs, nil
+}
+
+// Synced returns a copy of the tree as of the last DiffAndSync, i.e. the
+// state every reported diff has already been applied to. The copy shares its
+// nodes with the snapshot, modifying it copies them first.
+func (fss *Snapshot) Synced() *FileSystem {
+	// Forking takes ownership away from the synced tree, so it is a write.
+	fss.rwLocker.Lock()
+	defer fss.rwLocker.Unlock()
+
+	if fss.oldSnapshot == nil {
+		return nil
+	}
+	return fss.oldSnapshot.fork()
+}
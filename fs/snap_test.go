@@ -0,0 +1,132 @@
+package fs
+
+import (
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDiffAndSyncMatchesFullDiff applies random changes to a tree, feeding
+// every changed path to the snapshot like the watcher does, and checks that
+// the incremental diff reports what a full rebuild and diff would.
+func TestDiffAndSyncMatchesFullDiff(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(seed))
+			root := t.TempDir()
+			for i := 0; i < 20; i++ {
+				mutate(t, rng, root)
+			}
+
+			var snapshot Snapshot
+			if err := snapshot.Init(root); err != nil {
+				t.Fatal(err)
+			}
+			reference, err := NewFileSystem(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for batch := 0; batch < 20; batch++ {
+				for i := rng.Intn(5); i >= 0; i-- {
+					for _, p := range mutate(t, rng, root) {
+						if err := snapshot.UpdateChangedDir(p); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+
+				got := snapshot.DiffAndSync()
+				rebuilt, err := NewFileSystem(root)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Logf("got %v\nwant %v", got, reference.Diff(rebuilt))
+				checkDiffs(t, got, reference.Diff(rebuilt))
+				if diffs := snapshot.Synced().Diff(rebuilt); len(diffs) != 0 {
+					t.Fatalf("batch %d: synced tree differs from the disk: %v", batch, diffs)
+				}
+				reference = rebuilt
+			}
+		})
+	}
+}
+
+// mutate makes one random change below root and returns the paths it touched.
+func mutate(t *testing.T, rng *rand.Rand, root string) []string {
+	t.Helper()
+	var dirs, files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			dirs = append(dirs, p)
+		} else {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pick := func(paths []string) string { return paths[rng.Intn(len(paths))] }
+	name := func() string { return fmt.Sprintf("n%d", rng.Intn(8)) }
+
+	var touched []string
+	do := func(err error, paths ...string) {
+		if err != nil && !os.IsExist(err) && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		if err == nil {
+			touched = append(touched, paths...)
+		}
+	}
+	switch op := rng.Intn(7); {
+	case op == 0:
+		p := filepath.Join(pick(dirs), name())
+		do(os.Mkdir(p, 0o755), p)
+	case op == 1 || len(files) == 0:
+		p := filepath.Join(pick(dirs), name())
+		if _, err := os.Stat(p); err == nil {
+			break
+		}
+		do(os.WriteFile(p, []byte("x"), 0o644), p)
+	case op == 2:
+		// Growing the file changes its size whatever the mtime resolution.
+		p := pick(files)
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0)
+		if err == nil {
+			_, err = f.Write([]byte("x"))
+			f.Close()
+		}
+		do(err, p)
+	case op == 3:
+		p := pick(files)
+		mode := os.FileMode(0o644)
+		if info, err := os.Stat(p); err == nil && info.Mode().Perm() == mode {
+			mode = 0o600
+		}
+		do(os.Chmod(p, mode), p)
+	case op == 4:
+		p := pick(files)
+		do(os.Remove(p), p)
+	case op == 5 && len(dirs) > 1:
+		p := pick(dirs[1:])
+		do(os.RemoveAll(p), p)
+	default:
+		all := append(dirs[1:], files...)
+		if len(all) == 0 {
+			break
+		}
+		from := pick(all)
+		to := filepath.Join(pick(dirs), name())
+		if _, err := os.Lstat(to); err == nil || to == from || isUnder(filepath.ToSlash(to), filepath.ToSlash(from)) {
+			break
+		}
+		do(os.Rename(from, to), from, to)
+	}
+	return touched
+}
@@ -0,0 +1,85 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetargetedSymlink(t *testing.T) {
+	for _, follow := range []bool{false, true} {
+		root := t.TempDir()
+		for name, content := range map[string]string{"x": "1", "y": "22"} {
+			if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		link := filepath.Join(root, "l")
+		if err := os.Symlink("x", link); err != nil {
+			t.Fatal(err)
+		}
+		var opts []Option
+		if follow {
+			opts = append(opts, WithFollowSymlinks())
+		}
+		before, err := NewFileSystem(root, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Remove(link); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("y", link); err != nil {
+			t.Fatal(err)
+		}
+		after, err := NewFileSystem(root, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		diffs := before.Diff(after)
+		checkDiffs(t, diffs, []Diff{{Op: Retargeted, Path: "l"}})
+		if len(diffs) == 1 && diffs[0].Target != "y" {
+			t.Errorf("follow %v: target %q, want y", follow, diffs[0].Target)
+		}
+	}
+}
+
+func TestSymlinkLoop(t *testing.T) {
+	root := t.TempDir()
+	loop := filepath.Join(root, "a", "loop")
+	if err := os.Mkdir(filepath.Dir(loop), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..", loop); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var tree *FileSystem
+	var err error
+	go func() {
+		defer close(done)
+		tree, err = NewFileSystem(root, WithFollowSymlinks())
+		if err == nil {
+			err = tree.Update(loop)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("scanning a link loop did not terminate")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := tree.Root.child("a").child("loop")
+	if n == nil || !n.IsSymlink() {
+		t.Fatalf("a/loop = %+v, want the link itself", n)
+	}
+	if len(n.Children) != 0 {
+		t.Errorf("a/loop has %d children, the loop was followed", len(n.Children))
+	}
+}
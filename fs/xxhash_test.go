@@ -0,0 +1,54 @@
+package fs
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func TestXXH64(t *testing.T) {
+	tests := []struct {
+		input string
+		want  uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		// Longer than a 32 byte stripe.
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			h := newXXH64()
+			h.Write([]byte(tt.input))
+			if got := h.Sum64(); got != tt.want {
+				t.Errorf("Sum64 = %016x, want %016x", got, tt.want)
+			}
+			if got, want := hex.EncodeToString(h.Sum(nil)), fmt.Sprintf("%016x", tt.want); got != want {
+				t.Errorf("Sum = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// Splitting the input over several writes must not change the hash.
+func TestXXH64Streaming(t *testing.T) {
+	input := make([]byte, 1000)
+	for i := range input {
+		input[i] = byte(i * 7)
+	}
+	for _, size := range []int{0, 1, 31, 32, 33, 64, 100, 999, 1000} {
+		h := newXXH64()
+		h.Write(input[:size])
+		want := h.Sum64()
+
+		for split := 0; split <= size; split++ {
+			h.Reset()
+			h.Write(input[:split])
+			h.Write(input[split:size])
+			if got := h.Sum64(); got != want {
+				t.Fatalf("size %d split at %d: %016x, want %016x", size, split, got, want)
+			}
+		}
+	}
+}
@@ -5,6 +5,8 @@ go 1.21.1
 require (
 	github.com/atmshang/plog v0.0.0-20231011054856-66a0d9b0c0ed
 	github.com/fsnotify/fsnotify v1.6.0
+	github.com/reactivex/rxgo/v2 v2.5.0
+	golang.org/x/sys v0.0.0-20220908164124-27713097b956
 )
 
 require (
@@ -12,10 +14,8 @@ require (
 	github.com/davecgh/go-spew v1.1.0 // indirect
 	github.com/emirpasic/gods v1.12.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/reactivex/rxgo/v2 v2.5.0 // indirect
 	github.com/stretchr/objx v0.1.0 // indirect
 	github.com/stretchr/testify v1.4.0 // indirect
 	github.com/teivah/onecontext v0.0.0-20200513185103-40f981bfd775 // indirect
-	golang.org/x/sys v0.0.0-20220908164124-27713097b956 // indirect
 	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
@@ -0,0 +1,89 @@
+package rxfsnotify
+
+import (
+	"encoding/json"
+	"github.com/atmshang/plog"
+	"github.com/atmshang/rxfsnotify/fs"
+	"github.com/atmshang/rxfsnotify/journal"
+	"time"
+)
+
+// openJournal 打开 WithJournal 指定的日志，新事件的编号接在日志里最大的编号之后
+func (w *Watcher) openJournal() error {
+	if w.opts.journalDir == "" {
+		return nil
+	}
+	j, err := journal.Open(w.opts.journalDir, w.opts.journalOpts...)
+	if err != nil {
+		return &RootError{Root: w.opts.journalDir, Err: err}
+	}
+	w.journal = j
+	w.seq.Store(j.LastSeq())
+	return nil
+}
+
+// nextEvent 给事件编号，开启日志时先把事件写入日志
+func (w *Watcher) nextEvent(r *watchedRoot, diff fs.Diff, observed map[string]time.Time, now time.Time, source eventSource) CallBackEvent {
+	cbe := newCallBackEvent(r.path, diff, source)
+	cbe.ObservedAt = observedAt(observed, cbe.Path, now)
+
+	// 编号和写入日志要在同一把锁里，保证日志中的编号递增
+	w.journalLocker.Lock()
+	defer w.journalLocker.Unlock()
+
+	cbe.Seq = w.seq.Add(1)
+	if w.journal != nil {
+		data, err := json.Marshal(cbe)
+		if err == nil {
+			err = w.journal.Append(cbe.Seq, data)
+		}
+		if err != nil {
+			plog.Println("写入日志失败：", err)
+			w.channel.publishError(err)
+		}
+	}
+	return cbe
+}
+
+// syncJournal 让一批事件在投递前落盘
+func (w *Watcher) syncJournal() {
+	if w.journal == nil {
+		return
+	}
+	if err := w.journal.Sync(); err != nil {
+		plog.Println("同步日志失败：", err)
+		w.channel.publishError(err)
+	}
+}
+
+// redeliver 在第一次启动时重新投递上次运行中没有被确认的事件
+func (w *Watcher) redeliver() {
+	if w.journal == nil || w.redelivered {
+		return
+	}
+	w.redelivered = true
+
+	records, err := w.journal.Pending()
+	if err != nil {
+		plog.Println("读取日志失败：", err)
+		w.channel.publishError(err)
+		return
+	}
+	for _, record := range records {
+		var cbe CallBackEvent
+		if err := json.Unmarshal(record.Data, &cbe); err != nil {
+			continue
+		}
+		cbe.Redelivered = true
+		w.sendFileEvent(cbe)
+	}
+}
+
+// Ack 确认 seq 对应的事件已经处理完，确认过的事件不会在重启后重新投递。
+// 没有开启 WithJournal 时什么都不做
+func (w *Watcher) Ack(seq uint64) error {
+	if w.journal == nil {
+		return nil
+	}
+	return w.journal.Ack(seq)
+}
@@ -0,0 +1,395 @@
+// Package journal implements an append-only write-ahead log of records keyed
+// by increasing sequence numbers. Records stay in the journal until they are
+// acknowledged, so they survive a crash and can be delivered again.
+package journal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const ackFileName = "acks"
+
+var (
+	ErrClosed     = errors.New("journal: closed")
+	ErrOutOfOrder = errors.New("journal: sequence number is not increasing")
+)
+
+// Record is one entry of the journal.
+type Record struct {
+	Seq  uint64
+	Data []byte
+}
+
+// Option configures a Journal.
+type Option func(*options)
+
+type options struct {
+	segmentSize int64
+}
+
+// SegmentSize sets the size after which a new segment file is started,
+// 16 MiB by default. Segments whose records are all acknowledged are deleted.
+func SegmentSize(n int64) Option {
+	return func(o *options) {
+		o.segmentSize = n
+	}
+}
+
+// Journal is safe for concurrent use.
+type Journal struct {
+	dir  string
+	opts options
+
+	mu       sync.Mutex
+	closed   bool
+	segments []*segment
+	// active is the segment appended to, it is always the last one.
+	active     *os.File
+	activeSize int64
+	lastSeq    uint64
+
+	acks *os.File
+	// ackCount is the number of entries in the ack file, acked the ones still
+	// referring to a live segment. The file is rewritten when it grows too
+	// far beyond the live set.
+	ackCount int
+	acked    map[uint64]bool
+}
+
+// Open opens the journal in dir, creating it if needed. Records appended and
+// not acknowledged before the journal was last closed, or before a crash,
+// are reported by Pending.
+func Open(dir string, opts ...Option) (*Journal, error) {
+	o := options{segmentSize: 16 << 20}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	j := &Journal{dir: dir, opts: o, acked: make(map[uint64]bool)}
+	acked, err := readAcks(filepath.Join(dir, ackFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, seg := range segments {
+		end, err := readRecords(seg.path, func(r Record) {
+			if acked[r.Seq] {
+				j.acked[r.Seq] = true
+			} else {
+				seg.pending[r.Seq] = true
+			}
+			j.lastSeq = max(j.lastSeq, r.Seq)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if i == len(segments)-1 {
+			// Drop a torn tail so the next segment does not follow garbage.
+			if err := os.Truncate(seg.path, end); err != nil {
+				return nil, err
+			}
+			// The newest segment may have lost all its records, while the
+			// older ones were compacted away, but its name still tells
+			// which sequence numbers were handed out.
+			if seg.first > 0 {
+				j.lastSeq = max(j.lastSeq, seg.first-1)
+			}
+		}
+	}
+	j.segments = segments
+	if err := j.compactLocked(); err != nil {
+		return nil, err
+	}
+	if err := j.rewriteAcksLocked(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// LastSeq returns the highest sequence number ever appended, including
+// acknowledged records that were compacted away in this session.
+func (j *Journal) LastSeq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.lastSeq
+}
+
+// Append writes a record. Sequence numbers must increase. The record is not
+// durable until Sync returns.
+func (j *Journal) Append(seq uint64, data []byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return ErrClosed
+	}
+	if seq <= j.lastSeq {
+		return fmt.Errorf("%w: %d after %d", ErrOutOfOrder, seq, j.lastSeq)
+	}
+	if j.active == nil || j.activeSize >= j.opts.segmentSize {
+		if err := j.rotateLocked(seq); err != nil {
+			return err
+		}
+	}
+
+	n, err := encodeRecord(j.active, Record{Seq: seq, Data: data})
+	j.activeSize += int64(n)
+	if err != nil {
+		return err
+	}
+	j.segments[len(j.segments)-1].pending[seq] = true
+	j.lastSeq = seq
+	return nil
+}
+
+// Sync flushes appended records and acknowledgements to stable storage.
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return ErrClosed
+	}
+	var errs []error
+	if j.active != nil {
+		errs = append(errs, j.active.Sync())
+	}
+	errs = append(errs, j.acks.Sync())
+	return errors.Join(errs...)
+}
+
+// Ack marks the record seq as processed. Unknown or already acknowledged
+// sequence numbers are ignored.
+func (j *Journal) Ack(seq uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return ErrClosed
+	}
+	seg := j.segmentOf(seq)
+	if seg == nil || !seg.pending[seq] {
+		return nil
+	}
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], seq)
+	if _, err := j.acks.Write(buf[:]); err != nil {
+		return err
+	}
+	j.ackCount++
+	delete(seg.pending, seq)
+	j.acked[seq] = true
+
+	if len(seg.pending) == 0 {
+		if err := j.compactLocked(); err != nil {
+			return err
+		}
+	}
+	if j.ackCount > 2*len(j.acked)+1024 {
+		return j.rewriteAcksLocked()
+	}
+	return nil
+}
+
+// Pending returns the records not acknowledged yet, ordered by sequence.
+func (j *Journal) Pending() ([]Record, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return nil, ErrClosed
+	}
+	var records []Record
+	for _, seg := range j.segments {
+		if len(seg.pending) == 0 {
+			continue
+		}
+		_, err := readRecords(seg.path, func(r Record) {
+			if seg.pending[r.Seq] {
+				records = append(records, r)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// Close syncs and closes the journal.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return nil
+	}
+	j.closed = true
+	var errs []error
+	if j.active != nil {
+		errs = append(errs, j.active.Sync(), j.active.Close())
+	}
+	errs = append(errs, j.acks.Sync(), j.acks.Close())
+	return errors.Join(errs...)
+}
+
+// segmentOf finds the segment that would hold seq.
+func (j *Journal) segmentOf(seq uint64) *segment {
+	i := sort.Search(len(j.segments), func(i int) bool {
+		return j.segments[i].first > seq
+	})
+	if i == 0 {
+		return nil
+	}
+	return j.segments[i-1]
+}
+
+func (j *Journal) rotateLocked(first uint64) error {
+	if j.active != nil {
+		if err := j.active.Sync(); err != nil {
+			return err
+		}
+		if err := j.active.Close(); err != nil {
+			return err
+		}
+		j.active = nil
+	}
+
+	// A segment emptied by a torn tail has the same name and is truncated
+	// below, it must not be compacted away as a separate segment.
+	if n := len(j.segments); n > 0 && j.segments[n-1].first == first {
+		j.segments[n-1] = nil
+		j.segments = j.segments[:n-1]
+	}
+	seg := &segment{
+		path:    filepath.Join(j.dir, segmentName(first)),
+		first:   first,
+		pending: make(map[uint64]bool),
+	}
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	j.active = f
+	j.activeSize = 0
+	j.segments = append(j.segments, seg)
+	// Fully acknowledged segments could not be removed while they were newest.
+	return j.compactLocked()
+}
+
+// compactLocked deletes segments that have no pending records. The newest
+// segment is kept, it is either being appended to or remembers the last
+// sequence number across restarts.
+func (j *Journal) compactLocked() error {
+	kept := j.segments[:0]
+	for i, seg := range j.segments {
+		if len(seg.pending) > 0 || i == len(j.segments)-1 {
+			kept = append(kept, seg)
+			continue
+		}
+		if err := os.Remove(seg.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	for i := len(kept); i < len(j.segments); i++ {
+		j.segments[i] = nil
+	}
+	j.segments = kept
+
+	// Acks for removed segments are no longer needed.
+	if len(j.segments) == 0 {
+		clear(j.acked)
+		return nil
+	}
+	oldest := j.segments[0].first
+	for seq := range j.acked {
+		if seq < oldest {
+			delete(j.acked, seq)
+		}
+	}
+	return nil
+}
+
+// rewriteAcksLocked atomically replaces the ack file with the live acks.
+func (j *Journal) rewriteAcksLocked() error {
+	path := filepath.Join(j.dir, ackFileName)
+	tmp, err := os.CreateTemp(j.dir, ackFileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	seqs := make([]uint64, 0, len(j.acked))
+	for seq := range j.acked {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(a, b int) bool { return seqs[a] < seqs[b] })
+
+	bw := bufio.NewWriter(tmp)
+	for _, seq := range seqs {
+		err = binary.Write(bw, binary.BigEndian, seq)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	if j.acks != nil {
+		_ = j.acks.Close()
+	}
+	j.acks, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	j.ackCount = len(seqs)
+	return err
+}
+
+// readAcks reads the ack file, ignoring a torn last entry.
+func readAcks(path string) (map[uint64]bool, error) {
+	acked := make(map[uint64]bool)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return acked, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var buf [8]byte
+	for {
+		if _, err := io.ReadFull(br, buf[:]); err != nil {
+			return acked, nil
+		}
+		acked[binary.BigEndian.Uint64(buf[:])] = true
+	}
+}
@@ -0,0 +1,254 @@
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// step is applied to the journal in order: appends, then acks, then the
+// optional crash or reopen.
+type step struct {
+	append []uint64
+	ack    []uint64
+	// outOfOrder is appended expecting ErrOutOfOrder.
+	outOfOrder uint64
+	// tear closes the journal, cuts that many bytes from the newest segment
+	// as a crash in the middle of a write would, and opens it again.
+	tear int64
+	// reopen closes and opens the journal again.
+	reopen bool
+}
+
+func data(seq uint64) []byte {
+	return []byte(fmt.Sprintf("event %d", seq))
+}
+
+func TestJournal(t *testing.T) {
+	tests := []struct {
+		name        string
+		segmentSize int64
+		steps       []step
+		pending     []uint64
+		lastSeq     uint64
+		segments    int
+	}{
+		{
+			name:     "unacknowledged records are delivered again after reopen",
+			steps:    []step{{append: []uint64{1, 2, 3}, ack: []uint64{2}, reopen: true}},
+			pending:  []uint64{1, 3},
+			lastSeq:  3,
+			segments: 1,
+		},
+		{
+			name: "acks survive several reopens",
+			steps: []step{
+				{append: []uint64{1, 2, 3}, reopen: true},
+				{ack: []uint64{1}, reopen: true},
+				{append: []uint64{4}, ack: []uint64{3}, reopen: true},
+			},
+			pending:  []uint64{2, 4},
+			lastSeq:  4,
+			segments: 2,
+		},
+		{
+			name:     "torn tail is dropped",
+			steps:    []step{{append: []uint64{1, 2}, tear: 3}},
+			pending:  []uint64{1},
+			lastSeq:  1,
+			segments: 1,
+		},
+		{
+			name: "append after a torn tail",
+			steps: []step{
+				{append: []uint64{1, 2}, tear: 3},
+				{append: []uint64{2, 3}, reopen: true},
+			},
+			pending:  []uint64{1, 2, 3},
+			lastSeq:  3,
+			segments: 2,
+		},
+		{
+			name:        "segments rotate at the size limit",
+			segmentSize: 1,
+			steps:       []step{{append: []uint64{1, 2, 3, 4}, reopen: true}},
+			pending:     []uint64{1, 2, 3, 4},
+			lastSeq:     4,
+			segments:    4,
+		},
+		{
+			name:        "acknowledged segments are compacted",
+			segmentSize: 1,
+			steps:       []step{{append: []uint64{1, 2, 3, 4}, ack: []uint64{1, 2, 4}, reopen: true}},
+			pending:     []uint64{3},
+			lastSeq:     4,
+			segments:    2,
+		},
+		{
+			name:        "newest segment is kept to remember the sequence",
+			segmentSize: 1,
+			steps: []step{
+				{append: []uint64{1, 2, 3}, ack: []uint64{1, 2, 3}, reopen: true},
+				{outOfOrder: 3},
+			},
+			lastSeq:  3,
+			segments: 1,
+		},
+		{
+			name:        "emptied newest segment after compaction",
+			segmentSize: 1,
+			steps: []step{
+				{append: []uint64{1, 2, 3}, ack: []uint64{1, 2}},
+				{tear: 100},
+				{outOfOrder: 2},
+				{append: []uint64{3}, reopen: true},
+			},
+			pending:  []uint64{3},
+			lastSeq:  3,
+			segments: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var opts []Option
+			if tt.segmentSize > 0 {
+				opts = append(opts, SegmentSize(tt.segmentSize))
+			}
+			j, err := Open(dir, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { j.Close() }()
+
+			reopen := func() {
+				t.Helper()
+				if err := j.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if j, err = Open(dir, opts...); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, s := range tt.steps {
+				for _, seq := range s.append {
+					if err := j.Append(seq, data(seq)); err != nil {
+						t.Fatalf("Append(%d): %v", seq, err)
+					}
+				}
+				if err := j.Sync(); err != nil {
+					t.Fatal(err)
+				}
+				for _, seq := range s.ack {
+					if err := j.Ack(seq); err != nil {
+						t.Fatalf("Ack(%d): %v", seq, err)
+					}
+				}
+				if s.outOfOrder > 0 {
+					if err := j.Append(s.outOfOrder, data(s.outOfOrder)); !errors.Is(err, ErrOutOfOrder) {
+						t.Fatalf("Append(%d) = %v, want ErrOutOfOrder", s.outOfOrder, err)
+					}
+				}
+				if s.tear > 0 {
+					if err := j.Close(); err != nil {
+						t.Fatal(err)
+					}
+					tear(t, dir, s.tear)
+					if j, err = Open(dir, opts...); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if s.reopen {
+					reopen()
+				}
+			}
+
+			records, err := j.Pending()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pending []uint64
+			for _, r := range records {
+				if string(r.Data) != string(data(r.Seq)) {
+					t.Errorf("record %d has data %q", r.Seq, r.Data)
+				}
+				pending = append(pending, r.Seq)
+			}
+			if !slices.Equal(pending, tt.pending) {
+				t.Errorf("pending = %v, want %v", pending, tt.pending)
+			}
+			if got := j.LastSeq(); got != tt.lastSeq {
+				t.Errorf("LastSeq = %d, want %d", got, tt.lastSeq)
+			}
+			segments, err := listSegments(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(segments) != tt.segments {
+				t.Errorf("%d segments, want %d", len(segments), tt.segments)
+			}
+		})
+	}
+}
+
+// tear cuts n bytes from the end of the newest segment in dir.
+func tear(t *testing.T, dir string, n int64) {
+	t.Helper()
+	segments, err := listSegments(dir)
+	if err != nil || len(segments) == 0 {
+		t.Fatalf("no segment to tear: %v", err)
+	}
+	path := segments[len(segments)-1].path
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, max(stat.Size()-n, 0)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenIgnoresTornAck(t *testing.T) {
+	dir := t.TempDir()
+	j, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := uint64(1); seq <= 2; seq++ {
+		if err := j.Append(seq, data(seq)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Ack(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Half of an ack for seq 2.
+	f, err := os.OpenFile(filepath.Join(dir, ackFileName), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{0, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	j, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	records, err := j.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Seq != 2 {
+		t.Errorf("pending = %v, want only seq 2", records)
+	}
+}
@@ -0,0 +1,124 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Every record is a fixed header followed by its data:
+//
+//	length uint32 | crc32 of seq and data uint32 | seq uint64 | data
+const headerSize = 16
+
+const segmentExt = ".seg"
+
+// maxRecordSize bounds the length read from a header, so a corrupt length
+// cannot make the reader allocate gigabytes.
+const maxRecordSize = 64 << 20
+
+var errCorrupt = errors.New("journal: corrupt record")
+
+// segment is one file of the journal. Its name is the sequence number of its
+// first record, so sorting names sorts segments.
+type segment struct {
+	path  string
+	first uint64
+	// pending holds the sequence numbers in the segment not acknowledged yet.
+	pending map[uint64]bool
+}
+
+func segmentName(first uint64) string {
+	return fmt.Sprintf("%020d%s", first, segmentExt)
+}
+
+func parseSegmentName(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, segmentExt) {
+		return 0, false
+	}
+	first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+	return first, err == nil
+}
+
+func encodeRecord(w io.Writer, r Record) (int, error) {
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header[0:], uint32(len(r.Data)))
+	binary.BigEndian.PutUint64(header[8:], r.Seq)
+	crc := crc32.NewIEEE()
+	crc.Write(header[8:])
+	crc.Write(r.Data)
+	binary.BigEndian.PutUint32(header[4:], crc.Sum32())
+
+	n, err := w.Write(header)
+	if err != nil {
+		return n, err
+	}
+	m, err := w.Write(r.Data)
+	return n + m, err
+}
+
+// readRecords calls fn for every intact record of the segment at path and
+// returns the offset just past the last one. A torn or corrupt tail, left by
+// a crash in the middle of a write, ends the scan without an error.
+func readRecords(path string, fn func(r Record)) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var offset int64
+	header := make([]byte, headerSize)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			return offset, nil
+		}
+		length := binary.BigEndian.Uint32(header[0:])
+		if length > maxRecordSize {
+			return offset, nil
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return offset, nil
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(header[8:])
+		crc.Write(data)
+		if crc.Sum32() != binary.BigEndian.Uint32(header[4:]) {
+			return offset, nil
+		}
+
+		fn(Record{Seq: binary.BigEndian.Uint64(header[8:]), Data: data})
+		offset += headerSize + int64(length)
+	}
+}
+
+// listSegments returns the segments in dir ordered by their first sequence.
+func listSegments(dir string) ([]*segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []*segment
+	for _, entry := range entries {
+		first, ok := parseSegmentName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		segments = append(segments, &segment{
+			path:    filepath.Join(dir, entry.Name()),
+			first:   first,
+			pending: make(map[uint64]bool),
+		})
+	}
+	// ReadDir sorts by name and names are zero padded.
+	return segments, nil
+}
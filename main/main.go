@@ -1,16 +1,19 @@
 package main
 
 import (
+	"context"
 	"github.com/atmshang/plog"
 	"github.com/atmshang/rxfsnotify"
 	"log"
+	"os"
+	"os/signal"
 )
 
 type MyCallback struct{}
 
 func (cb *MyCallback) OnPathChanged(cbe rxfsnotify.CallBackEvent) {
 	// 处理路径变化事件
-	log.Println("[SDK的回调] ", cbe.Path, cbe.Exist)
+	log.Println("[SDK的回调] ", cbe.Op, cbe.Path, cbe.OldPath)
 }
 
 func main() {
@@ -19,10 +22,20 @@ func main() {
 
 	callback := &MyCallback{}
 
-	plog.Println("SetPathCallbackListener")
-	rxfsnotify.SetPathCallbackListener(callback)
+	plog.Println("New")
+	watcher, err := rxfsnotify.New(rxfsnotify.WithCallback(callback))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer watcher.Close()
 
-	go rxfsnotify.Start("D:\\TempDir")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	select {}
+	err = watcher.Start(ctx, "D:\\TempDir")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	<-ctx.Done()
 }
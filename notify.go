@@ -1,239 +1,373 @@
 package rxfsnotify
 
 import (
+	"context"
+	"errors"
 	"github.com/atmshang/plog"
-	"github.com/atmshang/rxfsnotify/concurrent"
 	"github.com/atmshang/rxfsnotify/fs"
-	"github.com/fsnotify/fsnotify"
-	"io/ioutil"
-	"os"
+	"github.com/atmshang/rxfsnotify/journal"
+	"github.com/reactivex/rxgo/v2"
 	"path/filepath"
-	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var stopCh = make(chan bool)
-var wg sync.WaitGroup
-
-func Start(dirPath string) {
-	tryCloseCh()
-	refreshTaskQueue.Start()
-	stopCh = make(chan bool)
-	wg.Add(1)
-	run(dirPath)
-	refreshTaskQueue.CancelAll()
-}
-
-func GracefulStop() {
-	stopCh <- true
-	wg.Wait()
+// Watcher 持有一次监听所需的全部状态，多个 Watcher 之间互不影响
+type Watcher struct {
+	opts options
+
+	backend  Backend
+	backends map[Backend]bool
+	// fallbacks 是按轮询间隔共享的降级后端
+	fallbacks map[time.Duration]Backend
+	cb        IPathCallback
+	channel   *eventChannel
+	seq       atomic.Uint64
+	// inflight 是正在更新快照、对比或者等待投递的工作数，重放时用来判断流水线是否空闲
+	inflight atomic.Int64
+
+	journal       *journal.Journal
+	journalLocker sync.Mutex
+	redelivered   bool
+
+	subs       map[*subscription]struct{}
+	subsLocker sync.RWMutex
+	subsClosed bool
+
+	roots       map[string]*watchedRoot
+	rootsLocker sync.RWMutex
+	running     bool
+	// adding 是正在 AddRoot、还没放进 roots 的根目录，避免同一路径被重复添加
+	adding map[string]*watchedRoot
+
+	stateLocker sync.Mutex
+	runCtx      context.Context
+	cancel      context.CancelFunc
+	done        chan struct{}
+	closed      bool
+	wg          sync.WaitGroup
+
+	optLocker sync.Mutex
+
+	previousState map[string]*fs.FileSystem
+	stateFileLock sync.Mutex
+	// stateDirty 表示上次保存之后有新的快照被同步
+	stateDirty atomic.Bool
+
+	fileEventCh     chan rxgo.Item
+	fileEventDone   <-chan struct{}
+	fileEventLocker sync.RWMutex
 }
 
-var singleLocker sync.Mutex
-
-var snapshot fs.Snapshot
-
-// 这个方法只能单例运行
-func run(dirPath string) {
-	defer wg.Done()
-
-	singleLocker.Lock()
-	defer singleLocker.Unlock()
-
-	err := snapshot.Init(dirPath)
-	if err != nil {
-		plog.Panic(err)
+// New 创建一个独立的 Watcher，它拥有自己的后端、快照和任务队列。
+// 没有通过 WithBackend 指定后端时使用 fsnotify。
+func New(opts ...Option) (*Watcher, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
 	}
 
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		plog.Panic(err)
+	if o.backend == nil {
+		backend, err := NewFsnotifyBackend()
+		if err != nil {
+			return nil, classifyError("", err)
+		}
+		o.backend = backend
 	}
-	defer watcher.Close()
-
-	refreshWatchedPaths(watcher, []string{dirPath})
-
-	go fileFilter() //启动过滤器
 
-	go eventHandler(watcher) //注册观察回调
-
-	<-stopCh // 这里会阻塞，直到接收到来自GracefulStop的信号
-	plog.Println("主协程退出")
-	// 通知所有协程退出
-	tryCloseCh()
+	w := &Watcher{
+		opts:     o,
+		backend:  o.backend,
+		backends: make(map[Backend]bool),
+		cb:       o.callback,
+		channel:  newEventChannel(o.eventBuffer, o.overflowPolicy),
+		subs:     make(map[*subscription]struct{}),
+		roots:    make(map[string]*watchedRoot),
+		adding:   make(map[string]*watchedRoot),
+	}
+	if err := w.openJournal(); err != nil {
+		_ = o.backend.Close()
+		return nil, err
+	}
+	return w, nil
 }
 
-// 开始监视路径的变化。
-// 一个路径只能被监视一次；尝试多次监视同一路径将返回错误。尚不存在于文件系统上的路径无法被添加监视。如果路径被删除，监视将自动删除。
-// 如果将路径重命名到同一文件系统上的其他位置，监视将保持不变，但是如果路径被删除并重新创建，或者被移动到不同的文件系统，监视将被删除。
-// 在网络文件系统（NFS、SMB、FUSE 等）或特殊文件系统（/proc、/sys 等）上通常无法正常工作。
-// 所有目录中的文件都将被监视，包括在观察器启动后创建的新文件。子目录不会被监视（即非递归）。
-// 通常不建议仅监视单个文件（而不是目录），因为许多工具以原子方式更新文件。而不是直接写入文件，首先会写入临时文件，如果成功，则将临时文件移动到目标位置，删除原始文件，或者进行某种变体。原始文件上的监视器现在丢失了，因为它不再存在。
-// 相反，监视父目录并使用 Event.Name 过滤您不感兴趣的文件。在 [cmd/fsnotify/file.go] 中有一个示例。
-func refreshWatchedPaths(watcher *fsnotify.Watcher, dirPaths []string) {
-
-	watchedPaths := make(map[string]bool)
+// Start 开始监听 rootPaths 以及之前通过 AddRoot 添加的根目录。
+// 所有根目录都建立好快照和监听后才返回，任何一个失败都会返回 *RootError 并且不会开始监听。
+// 返回 nil 之后监听在后台运行，直到 ctx 被取消或者调用 Stop。
+func (w *Watcher) Start(ctx context.Context, rootPaths ...string) error {
+	w.stateLocker.Lock()
+	defer w.stateLocker.Unlock()
 
-	for _, dirPath := range dirPaths {
-		traverseDir(watchedPaths, dirPath)
+	if w.closed {
+		return ErrClosed
 	}
-	var finalPaths []string
-	for p := range watchedPaths {
-		finalPaths = append(finalPaths, p)
+	if w.done != nil {
+		select {
+		case <-w.done:
+			// 上一次运行已经因为 ctx 取消而结束
+			w.wg.Wait()
+			w.cancel = nil
+			w.done = nil
+		default:
+			return ErrAlreadyStarted
+		}
 	}
 
-	for _, dirPath := range finalPaths {
-		addWatchedPaths(watcher, dirPath)
+	// 启动失败时撤销本次调用添加的根目录
+	var added []string
+	rollback := func() {
+		for _, rootPath := range added {
+			_ = w.RemoveRoot(rootPath)
+		}
+	}
+	for _, rootPath := range rootPaths {
+		err := w.AddRoot(rootPath)
+		if err != nil && !errors.Is(err, ErrRootExists) {
+			rollback()
+			return err
+		}
+		if err == nil {
+			added = append(added, rootPath)
+		}
 	}
-}
-
-func traverseDir(watchedPaths map[string]bool, dirPath string) {
-	watchedPaths[dirPath] = true
 
-	files, err := ioutil.ReadDir(dirPath)
+	err := w.loadState()
 	if err != nil {
-		plog.Println(err)
-		return
+		rollback()
+		return err
 	}
 
-	for _, f := range files {
-		fp := filepath.Join(dirPath, f.Name())
-		if f.IsDir() {
-			watchedPaths[fp] = true
-			traverseDir(watchedPaths, fp)
-		} else {
-			// 不需要管文件
+	catchUp := make(map[*watchedRoot][]fs.Diff)
+	w.rootsLocker.Lock()
+	for _, r := range w.roots {
+		diffs, err := w.startRoot(r)
+		if err != nil {
+			w.rootsLocker.Unlock()
+			w.clearWatchedPaths()
+			rollback()
+			return err
 		}
+		catchUp[r] = diffs
+	}
+	w.rootsLocker.Unlock()
+
+	runCtx, cancel := context.WithCancel(ctx)
+	w.runCtx = runCtx
+	w.cancel = cancel
+	w.done = make(chan struct{})
+
+	fileEventCh := make(chan rxgo.Item)
+	w.fileEventLocker.Lock()
+	w.fileEventCh = fileEventCh
+	w.fileEventDone = runCtx.Done()
+	w.fileEventLocker.Unlock()
+
+	w.wg.Add(2)
+	go w.fileFilter(runCtx, fileEventCh) //启动过滤器
+	go w.waitForExit(runCtx, w.done)
+	if w.opts.stateFile != "" {
+		w.wg.Add(1)
+		go w.stateSaver(runCtx)
 	}
-}
-
-var addLocker sync.Mutex
-
-func addWatchedPaths(watcher *fsnotify.Watcher, dirPath string) {
-
-	addLocker.Lock()
-	defer addLocker.Unlock()
 
-	err := watcher.Remove(dirPath)
-	if err != nil {
-		//log.Println("[REMOVE4ADD] 移除观察失败：", err, dirPath)
-	} else {
-		//log.Println("[REMOVE4ADD] 移除观察成功：", dirPath)
+	// 上次没有确认的事件最先发出，然后是离线期间的变化，最后才是实时事件
+	w.redeliver()
+	for r, diffs := range catchUp {
+		w.dispatch(r, diffs, nil, sourceCatchUp)
 	}
+	w.stateDirty.Store(true)
 
-	err = watcher.Add(dirPath) //添加观察目录
-	if err != nil {
-		//log.Println("[ADD] 添加观察目录失败：", err, dirPath)
-		return
+	w.rootsLocker.Lock()
+	w.running = true
+	for backend := range w.backends {
+		w.wg.Add(1)
+		go w.eventHandler(runCtx, backend) //注册观察回调
 	}
-	//log.Println("[ADD] 添加观察目录成功：", dirPath)
+	w.rootsLocker.Unlock()
+	return nil
 }
 
-// fsnotify的文档有说，会自动移除不存在的监听，先不管他，但是rename的情况有bug，难顶
-func removeWatch(watcher *fsnotify.Watcher, event fsnotify.Event) {
-	addLocker.Lock()
-	defer addLocker.Unlock()
+// Stop 优雅停止监听并等待后台协程退出，之后可以再次 Start。未运行时调用不会有任何效果
+func (w *Watcher) Stop() {
+	w.stateLocker.Lock()
+	defer w.stateLocker.Unlock()
 
-	err := watcher.Remove(event.Name)
-	if err != nil {
-		//log.Println("[REMOVE] 移除观察失败：", err, event.Name)
+	if w.cancel == nil {
 		return
 	}
-	//log.Println("[REMOVE] 移除观察成功：", event.Name)
+	w.cancel()
+	w.wg.Wait()
+	w.cancel = nil
+	w.done = nil
 }
 
-var waitingRefreshDirMap = concurrent.NewSafeMap()
+// Close 停止监听并关闭用到的所有后端，Close 之后 Watcher 不可再使用
+func (w *Watcher) Close() error {
+	w.Stop()
 
-var refreshTaskQueue = concurrent.NewTaskQueue()
+	w.stateLocker.Lock()
+	defer w.stateLocker.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
 
-func innerNotify(event fsnotify.Event) {
-	_event := fileEvent{Path: event.Name, Event: event.Op.String()}
-	sendFileEvent(_event)
+	w.rootsLocker.Lock()
+	defer w.rootsLocker.Unlock()
+
+	w.backends[w.backend] = true
+	var errs []error
+	for backend := range w.backends {
+		errs = append(errs, backend.Close())
+	}
+	if w.journal != nil {
+		errs = append(errs, w.journal.Close())
+	}
+	w.channel.close()
+	w.closeSubscribers()
+	return errors.Join(errs...)
 }
 
-func innerProcessDir(watcher *fsnotify.Watcher, event fsnotify.Event) {
-	// 标记变更
-	waitingRefreshDirMap.Set(event.Name, true)
-	// 取消等待的任务
-	refreshTaskQueue.CancelAll()
-	// 发布新任务到未来
-	_ = refreshTaskQueue.AddTask(5000*time.Millisecond, func() {
-		dirPaths := waitingRefreshDirMap.ToList()
-		for _, dirPath := range dirPaths {
-			_event := fileEvent{Path: dirPath, Event: fsnotify.Create.String()}
-			plog.Println("批量发送文件夹新建的事件:", _event)
-			sendFileEvent(_event)
-		}
-		refreshWatchedPaths(watcher, dirPaths)
-	})
+// 等待 ctx 结束后清理本次运行的状态
+func (w *Watcher) waitForExit(ctx context.Context, done chan struct{}) {
+	defer w.wg.Done()
+	defer close(done)
 
+	<-ctx.Done()
+	plog.Println("决定优雅退出")
+	w.stateDirty.Store(false)
+	w.saveState()
+	w.clearWatchedPaths()
 }
 
-var optLocker sync.Mutex
+// 退出时让所有根目录停止监听，避免下次 Start 时残留
+func (w *Watcher) clearWatchedPaths() {
+	w.rootsLocker.Lock()
+	defer w.rootsLocker.Unlock()
 
-func singleLineOptSnapshot(dirPath string) {
-	optLocker.Lock()
-	defer optLocker.Unlock()
+	w.running = false
+	for _, r := range w.roots {
+		w.stopRoot(r)
+	}
+}
+
+func (w *Watcher) singleLineOptSnapshot(ctx context.Context, dirPath string, observedAt time.Time) {
+	w.optLocker.Lock()
+	defer w.optLocker.Unlock()
+	r := w.rootForEvent(dirPath, observedAt)
+	if r == nil {
+		return
+	}
+	reloaded := false
+	if reloader, ok := r.opts.filter.(filterReloader); ok && reloader.Reload(dirPath) {
+		// 忽略规则变了，整个目录都要按新规则重新监听和扫描
+		dirPath = filepath.Dir(dirPath)
+		reloaded = true
+	} else if stat, err := statPath(dirPath, r.opts.followSymlinks); err == nil && r.opts.filter != nil && r.opts.filter.Excluded(r.path, dirPath, stat.IsDir()) {
+		// 已经消失的路径交给快照处理，被排除的路径本来就不在快照里
+		return
+	}
+	r.observe(dirPath, observedAt)
+	w.inflight.Add(1)
 	go func() {
-		err := snapshot.UpdateChangedDir(dirPath)
+		defer w.inflight.Add(-1)
+		if ctx.Err() != nil {
+			return
+		}
+		if reloaded {
+			// 先监听再扫描，扫描之后的变化都能收到事件
+			w.refreshWatches(r, dirPath)
+		}
+		err := r.snapshot.UpdateChangedDir(dirPath)
 		if err != nil {
 			return
 		}
-		refreshTaskQueue.CancelAll()
-		_ = refreshTaskQueue.AddTask(5000*time.Millisecond, func() {
-			diffs := snapshot.DiffAndSync()
-			for _, diff := range diffs {
-				callback(diff.AbsPath, diff.Op != 0)
-			}
-		})
+		w.scheduleFlush(r)
 	}()
 }
 
-func eventHandler(watcher *fsnotify.Watcher) {
+// scheduleFlush 推迟根目录的对比，连续的变化会不断推迟，但最多推迟到 maxWait
+func (w *Watcher) scheduleFlush(r *watchedRoot) {
+	r.flushLocker.Lock()
+	defer r.flushLocker.Unlock()
+
+	now := time.Now()
+	if r.pendingSince.IsZero() {
+		r.pendingSince = now
+	}
+	delay := r.opts.debounce
+	if r.opts.maxWait > 0 {
+		remaining := r.pendingSince.Add(r.opts.maxWait).Sub(now)
+		if remaining < delay {
+			delay = max(remaining, 0)
+		}
+	}
+
+	r.flushQueue.CancelAll()
+	_ = r.flushQueue.AddTask(delay, func() {
+		w.flush(r)
+	})
+}
+
+func (w *Watcher) flush(r *watchedRoot) {
+	// 先计数再清除 pendingSince，等待空闲时不会错过正在进行的对比
+	w.inflight.Add(1)
+	defer w.inflight.Add(-1)
+
+	r.flushLocker.Lock()
+	r.pendingSince = time.Time{}
+	observed := r.observed
+	r.observed = nil
+	r.flushLocker.Unlock()
+
+	diffs := r.snapshot.DiffAndSync()
+	w.dispatch(r, diffs, observed, sourceLive)
+	if len(diffs) > 0 {
+		w.stateDirty.Store(true)
+	}
+}
+
+func (w *Watcher) eventHandler(ctx context.Context, backend Backend) {
+	defer w.wg.Done()
+
 	for {
 		select {
-		case event, ok := <-watcher.Events:
-			if !ok {
-				plog.Println("监听事件管道发现：不OK")
-				continue
+		case event := <-backend.Events():
+			if event.Time.IsZero() {
+				event.Time = time.Now()
 			}
-
-			singleLineOptSnapshot(event.Name)
-
-			// 判断状态
-			stat, err := os.Stat(event.Name)
-			if err != nil {
-				//plog.Println("这个文件不在啦：", event)
-				removeWatch(watcher, event)
-			} else {
-				//plog.Println("这个文件夹还在：", event)
-				if stat.IsDir() {
-					addWatchedPaths(watcher, event.Name)
-				}
+			if w.opts.recorder != nil {
+				w.opts.recorder.event(event)
 			}
-		case err, ok := <-watcher.Errors:
-			if !ok {
-				plog.Println("监听错误管道发现：不OK")
+			w.singleLineOptSnapshot(ctx, event.Path, event.Time)
+		case err := <-backend.Errors():
+			if errors.Is(err, ErrOverflow) {
+				w.resyncBackend(backend, err)
 				continue
 			}
+			var limitErr *WatchLimitError
+			if errors.As(err, &limitErr) {
+				w.handleWatchLimit(limitErr)
+			}
 			plog.Println("监听错误管道发现:", err)
-
-		case <-stopCh: // 当接收到来自GracefulStop的信号时，结束循环
-			plog.Println("决定优雅退出")
-			// 通知所有协程
-			tryCloseCh()
+			w.channel.publishError(err)
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func tryCloseCh() {
-	defer func() {
-		if r := recover(); r != nil {
-			// plog.Println("recover:", r)
-			debug.PrintStack()
-		}
-	}()
-	close(stopCh)
+// 为新用到的后端启动事件处理协程，调用方需要持有 rootsLocker
+func (w *Watcher) consumeBackendLocked(backend Backend) {
+	if w.backends[backend] {
+		return
+	}
+	w.backends[backend] = true
+	if w.running {
+		w.wg.Add(1)
+		go w.eventHandler(w.runCtx, backend)
+	}
 }
@@ -0,0 +1,189 @@
+package rxfsnotify
+
+import (
+	"context"
+	"github.com/atmshang/rxfsnotify/fs"
+	"github.com/reactivex/rxgo/v2"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Observable 返回一个发出 Event 的 rxgo.Observable，每次调用都是一个独立的订阅者，
+// 不影响回调、Events 通道和其他订阅者。ctx 结束或者 Close 之后取消订阅，Observable 结束。
+// 不再消费时必须结束 ctx，否则订阅者的队列会一直增长
+func (w *Watcher) Observable(ctx context.Context, opts ...rxgo.Option) rxgo.Observable {
+	out := make(chan rxgo.Item)
+	s := w.newSubscription(SubscriptionFilter{}, nil)
+	s.handler = PathCallbackFunc(func(cbe CallBackEvent) {
+		select {
+		case out <- rxgo.Of(cbe):
+		case <-s.done:
+		}
+	})
+	s.onExit = func() {
+		close(out)
+	}
+	if err := w.addSubscription(s); err != nil {
+		return rxgo.Thrown(err)
+	}
+	context.AfterFunc(ctx, s.Unsubscribe)
+	return rxgo.FromChannel(out, append([]rxgo.Option{rxgo.WithContext(ctx)}, opts...)...)
+}
+
+// Operator 把一个 Observable 变换成另一个，可以用 Pipe 依次组合
+type Operator func(rxgo.Observable) rxgo.Observable
+
+// Pipe 依次把 ops 应用到 observable 上
+func Pipe(observable rxgo.Observable, ops ...Operator) rxgo.Observable {
+	for _, op := range ops {
+		observable = op(observable)
+	}
+	return observable
+}
+
+// DebounceByPath 每个路径安静 d 之后才发出它的事件，期间的事件会被合并，
+// 例如 Created 之后的 Modified 仍然是 Created，Created 之后的 Removed 两者都不再发出。
+// 上游结束时还没发出的事件会立即按路径顺序发出。ctx 结束后不再发出任何事件，应和 Observable 使用同一个 ctx
+func DebounceByPath(ctx context.Context, d time.Duration) Operator {
+	return func(src rxgo.Observable) rxgo.Observable {
+		out := make(chan rxgo.Item)
+		go func() {
+			defer close(out)
+
+			type timerKey struct {
+				path string
+				gen  int
+			}
+			pending := make(map[string]Event)
+			gens := make(map[string]int)
+			fire := make(chan timerKey)
+			done := make(chan struct{})
+			defer close(done)
+			send := func(item rxgo.Item) bool {
+				select {
+				case out <- item:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			}
+
+			in := src.Observe()
+			for in != nil {
+				select {
+				case item, ok := <-in:
+					if !ok {
+						in = nil
+						break
+					}
+					e, ok := item.V.(Event)
+					if !ok {
+						if !send(item) {
+							return
+						}
+						continue
+					}
+					gens[e.Path]++
+					// 合并后可能变成旧路径的事件，继续和旧路径上的事件合并
+					merged := true
+					for merged {
+						old, ok := pending[e.Path]
+						if !ok {
+							break
+						}
+						path := e.Path
+						e, merged = mergeEvents(old, e)
+						delete(pending, path)
+						if merged && e.Path == path {
+							break
+						}
+						gens[e.Path]++
+					}
+					if !merged {
+						continue
+					}
+					pending[e.Path] = e
+					key := timerKey{e.Path, gens[e.Path]}
+					time.AfterFunc(d, func() {
+						select {
+						case fire <- key:
+						case <-done:
+						}
+					})
+				case key := <-fire:
+					e, ok := pending[key.path]
+					if !ok || gens[key.path] != key.gen {
+						continue
+					}
+					delete(pending, key.path)
+					if !send(rxgo.Of(e)) {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			paths := make([]string, 0, len(pending))
+			for p := range pending {
+				paths = append(paths, p)
+			}
+			sort.Strings(paths)
+			for _, p := range paths {
+				if !send(rxgo.Of(pending[p])) {
+					return
+				}
+			}
+		}()
+		return rxgo.FromChannel(out, rxgo.WithContext(ctx))
+	}
+}
+
+// BufferByTimeOrCount 把事件攒成 []Event 发出，攒够 count 个或者每隔 d 发出一次，没有事件时不发出
+func BufferByTimeOrCount(d time.Duration, count int) Operator {
+	return func(src rxgo.Observable) rxgo.Observable {
+		return src.
+			BufferWithTimeOrCount(rxgo.WithDuration(d), count).
+			Map(func(_ context.Context, i interface{}) (interface{}, error) {
+				items := i.([]interface{})
+				events := make([]Event, 0, len(items))
+				for _, item := range items {
+					if e, ok := item.(Event); ok {
+						events = append(events, e)
+					}
+				}
+				return events, nil
+			})
+	}
+}
+
+// DistinctUntilChangedByHash 丢弃内容哈希和该路径上一次发出时相同的文件事件，
+// 例如只 touch 了修改时间或者写回了相同的内容。目录和删除事件总是发出
+func DistinctUntilChangedByHash() Operator {
+	return func(src rxgo.Observable) rxgo.Observable {
+		hashes := make(map[string][]byte)
+		return src.Filter(func(i interface{}) bool {
+			e, ok := i.(Event)
+			if !ok || e.IsDir {
+				return true
+			}
+			switch e.Op {
+			case Removed:
+				delete(hashes, e.Path)
+				return true
+			case Renamed:
+				delete(hashes, e.OldPath)
+			}
+
+			hash, err := fs.HashFile(e.Path)
+			if err != nil {
+				delete(hashes, e.Path)
+				return true
+			}
+			old, seen := hashes[e.Path]
+			hashes[e.Path] = hash
+			return !seen || e.Op != Modified && e.Op != AttribChanged || !slices.Equal(old, hash)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package rxfsnotify
+
+import (
+	"context"
+	"github.com/reactivex/rxgo/v2"
+	"testing"
+	"time"
+)
+
+func TestDebounceByPathRenamedThenRemoved(t *testing.T) {
+	src := rxgo.Just(
+		Event{Path: "/r/b", OldPath: "/r/a", Op: Renamed},
+		Event{Path: "/r/b", Op: Removed},
+	)()
+	var got []Event
+	for item := range DebounceByPath(context.Background(), time.Hour)(src).Observe(rxgo.WithContext(context.Background())) {
+		got = append(got, item.V.(Event))
+	}
+	if len(got) != 1 || got[0].Op != Removed || got[0].Path != "/r/a" {
+		t.Fatalf("got %+v, want a single Removed /r/a", got)
+	}
+}
+
+func TestObservableUnsubscribesWhenContextEnds(t *testing.T) {
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	items := Pipe(w.Observable(ctx), DebounceByPath(ctx, time.Hour)).Observe()
+	cancel()
+
+	select {
+	case _, ok := <-items:
+		for ok {
+			_, ok = <-items
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("observable did not end after its context was cancelled")
+	}
+	// 取消订阅在 ctx 结束后异步进行
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w.subsLocker.RLock()
+		left := len(w.subs)
+		w.subsLocker.RUnlock()
+		if left == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscriptions left after cancel", left)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
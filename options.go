@@ -0,0 +1,206 @@
+package rxfsnotify
+
+import (
+	"github.com/atmshang/rxfsnotify/fs"
+	"github.com/atmshang/rxfsnotify/journal"
+	"time"
+)
+
+// Option 用于在 New 时配置 Watcher
+type Option func(*options)
+
+type options struct {
+	callback          IPathCallback
+	hasher            fs.Option
+	stateFile         string
+	stateSaveInterval time.Duration
+	backend           Backend
+
+	debounce       time.Duration
+	maxWait        time.Duration
+	settleInterval time.Duration
+
+	writeCompletion WriteCompletion
+
+	filter fs.Filter
+
+	eventBuffer    int
+	overflowPolicy OverflowPolicy
+
+	concurrency int
+
+	journalDir  string
+	journalOpts []journal.Option
+
+	recorder *Recorder
+
+	pollingFallback time.Duration
+
+	followSymlinks bool
+}
+
+func defaultOptions() options {
+	return options{
+		debounce:          5000 * time.Millisecond,
+		settleInterval:    250 * time.Millisecond,
+		stateSaveInterval: 10 * time.Second,
+		eventBuffer:       64,
+		concurrency:       16,
+	}
+}
+
+// WithCallback 设置路径变化的回调，等价于创建后调用 SetPathCallbackListener
+func WithCallback(cb IPathCallback) Option {
+	return func(o *options) {
+		o.callback = cb
+	}
+}
+
+// WithContentHash 在快照中记录文件内容的 SHA-256，大小和修改时间都没变的改写也能被识别为修改，
+// 只改了修改时间的 touch 不再上报
+func WithContentHash() Option {
+	return WithHasher(fs.SHA256())
+}
+
+// WithHasher 同 WithContentHash，但使用指定的哈希算法，例如 fs.XXHash64，
+// 或者用 fs.NewHasher 接入 BLAKE3 等实现。哈希按 inode、大小和修改时间缓存，文件没变时不会重新读取
+func WithHasher(h fs.Hasher) Option {
+	hasher := fs.WithHasher(h)
+	return func(o *options) {
+		o.hasher = hasher
+	}
+}
+
+// WithStateFile 把已上报的快照保存到 path。启动时会加载上次的快照并重新扫描，
+// 离线期间的变化会在开始实时监听之前以 CatchUp 事件发出。
+// 快照在有新的变化时按 WithStateSaveInterval 的间隔保存，停止时再保存一次
+func WithStateFile(path string) Option {
+	return func(o *options) {
+		o.stateFile = path
+	}
+}
+
+// WithStateSaveInterval 设置运行中保存状态文件的最短间隔，默认 10 秒。
+// 保存要写出整个快照，间隔越短，异常退出后重复补报的变化越少，但大目录树上的开销越大
+func WithStateSaveInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.stateSaveInterval = d
+	}
+}
+
+// WithBackend 指定变化的来源，例如 NewPollingBackend。
+// 传给 New 时作用于所有根目录，传给 AddRoot 时只作用于该根目录
+func WithBackend(backend Backend) Option {
+	return func(o *options) {
+		o.backend = backend
+	}
+}
+
+// WithDebounce 设置批量对比的等待时间，最后一次变化之后安静 d 才会上报，默认 5 秒
+func WithDebounce(d time.Duration) Option {
+	return func(o *options) {
+		o.debounce = d
+	}
+}
+
+// WithMaxWait 设置从第一个变化到上报的最长等待时间，保证持续变化时也能定期上报，默认不限制
+func WithMaxWait(d time.Duration) Option {
+	return func(o *options) {
+		o.maxWait = d
+	}
+}
+
+// WithSettleInterval 设置 WriteCompletion 检查文件是否写完的轮询间隔，默认 250 毫秒
+func WithSettleInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.settleInterval = d
+	}
+}
+
+// WithWriteCompletion 设置判断文件是否写完的策略，例如 StableSize、CloseWrite、NoOpenWriters。
+// 默认不等待，文件的变化被发现后立即上报
+func WithWriteCompletion(wc WriteCompletion) Option {
+	return func(o *options) {
+		o.writeCompletion = wc
+	}
+}
+
+// WithFilter 设置路径过滤器，例如 filter.New 创建的过滤器。被排除的路径不会被监听、
+// 不会进入快照，也不会被上报
+func WithFilter(f fs.Filter) Option {
+	return func(o *options) {
+		o.filter = f
+	}
+}
+
+// WithEventBuffer 设置 Events 和 Errors 通道的缓冲大小，默认 64，只在 New 时生效。
+// OverflowDropOldest 和 OverflowCoalesce 下小于 1 时按 1 处理
+func WithEventBuffer(size int) Option {
+	return func(o *options) {
+		o.eventBuffer = size
+	}
+}
+
+// WithOverflowPolicy 设置 Events 通道满了之后的处理方式，默认 OverflowBlock，只在 New 时生效
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(o *options) {
+		o.overflowPolicy = policy
+	}
+}
+
+// WithConcurrency 设置同时处理事件的协程数，默认 16，只在 New 时生效。
+// 同一路径的事件总是按发生顺序依次处理，等待文件写完也会占用一个协程
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// WithJournal 在 dir 中保存事件日志，事件在投递前写入日志，用 Watcher.Ack 确认之后才会被清理。
+// 进程崩溃或回调出错导致没有确认的事件会在下次 Start 时以 Redelivered 事件重新投递，只在 New 时生效
+func WithJournal(dir string, opts ...journal.Option) Option {
+	return func(o *options) {
+		o.journalDir = dir
+		o.journalOpts = opts
+	}
+}
+
+// WithRecorder 把根目录的初始状态、后端的原始事件和回调事件录制到 rec，只在 New 时生效
+func WithRecorder(rec *Recorder) Option {
+	return func(o *options) {
+		o.recorder = rec
+	}
+}
+
+// WithPollingFallback 在 inotify 监听数量达到上限时，用间隔为 interval 的轮询覆盖没能监听的目录，
+// 保证变化仍然能被发现。默认不降级，只通过 Errors 通道报告 *WatchLimitError，interval 不是正数时同样不降级
+func WithPollingFallback(interval time.Duration) Option {
+	return func(o *options) {
+		o.pollingFallback = max(interval, 0)
+	}
+}
+
+// WithFollowSymlinks 跟随符号链接：指向目录的链接会被当作目录进入并监听，指向文件的链接按目标文件的内容上报修改。
+// 指回上级目录的链接按设备号和 inode 识别，只记录链接本身，不会无限循环。
+// 同一个目录通过多个路径可达时 inotify 只能按其中一个路径上报，应避免链接指向根目录内的其他目录。
+// 默认不跟随，链接被当作文件，指向改变时上报 Retargeted
+func WithFollowSymlinks() Option {
+	return func(o *options) {
+		o.followSymlinks = true
+	}
+}
+
+// 转换为构建快照时使用的选项
+func (o options) fsOptions() []fs.Option {
+	var result []fs.Option
+	if o.hasher != nil {
+		result = append(result, o.hasher)
+	}
+	if o.filter != nil {
+		result = append(result, fs.WithFilter(o.filter))
+	}
+	if o.followSymlinks {
+		result = append(result, fs.WithFollowSymlinks())
+	}
+	return result
+}
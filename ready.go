@@ -0,0 +1,65 @@
+package rxfsnotify
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// WriteCompletion 判断文件是否已经写完。文件的 Created、Modified、Renamed 事件
+// 会等 Wait 返回后才上报，避免消费方读到写了一半的文件
+type WriteCompletion interface {
+	// Wait 阻塞直到 path 写完或者消失，interval 是轮询间隔，返回文件是否还存在
+	Wait(ctx context.Context, path string, interval time.Duration) bool
+}
+
+// WriteCompletionFunc 让普通函数实现 WriteCompletion
+type WriteCompletionFunc func(ctx context.Context, path string, interval time.Duration) bool
+
+func (f WriteCompletionFunc) Wait(ctx context.Context, path string, interval time.Duration) bool {
+	return f(ctx, path, interval)
+}
+
+// OpenCheck 等到文件可以被打开为止，只在写入方会独占文件的平台（例如部分 Windows 程序）上有效，
+// Linux 上打开总是成功
+func OpenCheck() WriteCompletion {
+	return WriteCompletionFunc(checkFileUntilValidOrIdle)
+}
+
+// StableSize 等到文件的大小和修改时间连续 n 个间隔都没有变化
+func StableSize(n int) WriteCompletion {
+	return WriteCompletionFunc(func(ctx context.Context, path string, interval time.Duration) bool {
+		last, err := os.Stat(path)
+		if err != nil {
+			return false
+		}
+		for stable := 0; stable < n; {
+			if !sleepContext(ctx, interval) {
+				return isValidFile(path)
+			}
+			cur, err := os.Stat(path)
+			if err != nil {
+				return false
+			}
+			if cur.Size() == last.Size() && cur.ModTime().Equal(last.ModTime()) {
+				stable++
+			} else {
+				stable = 0
+			}
+			last = cur
+		}
+		return true
+	})
+}
+
+// 只有文件的内容变化需要等待写完
+func needsWriteCompletion(cbe CallBackEvent) bool {
+	if cbe.IsDir {
+		return false
+	}
+	switch cbe.Op {
+	case Created, Modified, Renamed:
+		return true
+	}
+	return false
+}
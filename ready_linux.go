@@ -0,0 +1,158 @@
+package rxfsnotify
+
+import (
+	"context"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// CloseWrite 用 inotify 的 IN_CLOSE_WRITE 判断写入方已经关闭文件。
+// 写入方可能在开始监听之前就关闭了文件，所以一个间隔内没有事件、文件没有变化、
+// 并且在 /proc 里看不到写入方时也认为写完了
+func CloseWrite() WriteCompletion {
+	return WriteCompletionFunc(waitCloseWrite)
+}
+
+// NoOpenWriters 扫描 /proc/*/fd，等到没有任何进程以写方式打开这个文件。
+// 只能看到有权限读取 /proc/<pid>/fd 的进程，通常需要和写入方是同一个用户或者 root
+func NoOpenWriters() WriteCompletion {
+	return WriteCompletionFunc(func(ctx context.Context, path string, interval time.Duration) bool {
+		for {
+			if !isValidFile(path) {
+				return false
+			}
+			if !openForWriting(path) {
+				return true
+			}
+			if !sleepContext(ctx, interval) {
+				return isValidFile(path)
+			}
+		}
+	})
+}
+
+func waitCloseWrite(ctx context.Context, path string, interval time.Duration) bool {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		// inotify 实例用完了，退化为大小稳定检查
+		return StableSize(2).Wait(ctx, path, interval)
+	}
+	defer unix.Close(fd)
+
+	_, err = unix.InotifyAddWatch(fd, path, unix.IN_CLOSE_WRITE|unix.IN_DELETE_SELF|unix.IN_MOVE_SELF)
+	if err != nil {
+		return isValidFile(path)
+	}
+	last, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	// 不到 1 毫秒的间隔会被截断成 0，Poll 立即返回，循环就会占满一个 CPU
+	timeout := max(int(interval.Milliseconds()), 1)
+	buf := make([]byte, 4096)
+	for {
+		if ctx.Err() != nil {
+			return isValidFile(path)
+		}
+		// 每次最多等一个间隔，以便响应 ctx 和处理监听之前就已经关闭的情况
+		n, err := unix.Poll([]unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}, timeout)
+		if err != nil && err != unix.EINTR {
+			return StableSize(2).Wait(ctx, path, interval)
+		}
+		if n > 0 {
+			mask := readInotifyMask(fd, buf)
+			if mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0 {
+				return false
+			}
+			if mask&unix.IN_CLOSE_WRITE != 0 {
+				return true
+			}
+			continue
+		}
+
+		// 一个间隔内没有事件：文件没变而且看不到写入方，说明在监听之前就已经关闭了
+		cur, err := os.Stat(path)
+		if err != nil {
+			return false
+		}
+		if cur.Size() == last.Size() && cur.ModTime().Equal(last.ModTime()) && !openForWriting(path) {
+			return true
+		}
+		last = cur
+	}
+}
+
+// readInotifyMask 读出当前所有的 inotify 事件并合并它们的掩码
+func readInotifyMask(fd int, buf []byte) uint32 {
+	var mask uint32
+	n, err := unix.Read(fd, buf)
+	if err != nil {
+		return 0
+	}
+	for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+		event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		mask |= event.Mask
+		offset += unix.SizeofInotifyEvent + int(event.Len)
+	}
+	return mask
+}
+
+// openForWriting 判断是否有进程以 O_WRONLY 或 O_RDWR 打开了 path
+func openForWriting(path string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return false
+	}
+	for _, proc := range procs {
+		if _, err := strconv.Atoi(proc.Name()); err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			// 进程已退出或者没有权限
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || link != absPath {
+				continue
+			}
+			flags, ok := fdFlags(filepath.Join("/proc", proc.Name(), "fdinfo", fd.Name()))
+			if ok && flags&(unix.O_WRONLY|unix.O_RDWR) != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fdFlags 读取 fdinfo 中以八进制表示的 flags
+func fdFlags(fdInfoPath string) (int, bool) {
+	data, err := os.ReadFile(fdInfoPath)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		value, ok := strings.CutPrefix(line, "flags:")
+		if !ok {
+			continue
+		}
+		flags, err := strconv.ParseInt(strings.TrimSpace(value), 8, 64)
+		if err != nil {
+			return 0, false
+		}
+		return int(flags), true
+	}
+	return 0, false
+}
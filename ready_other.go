@@ -0,0 +1,13 @@
+//go:build !linux
+
+package rxfsnotify
+
+// CloseWrite 依赖 Linux 的 inotify，其他平台退化为 StableSize(2)
+func CloseWrite() WriteCompletion {
+	return StableSize(2)
+}
+
+// NoOpenWriters 依赖 Linux 的 /proc，其他平台退化为 StableSize(2)
+func NoOpenWriters() WriteCompletion {
+	return StableSize(2)
+}
@@ -0,0 +1,81 @@
+package rxfsnotify
+
+import (
+	"encoding/json"
+	"github.com/atmshang/rxfsnotify/fs"
+	"github.com/fsnotify/fsnotify"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	recordRoot     = "root"
+	recordEvent    = "event"
+	recordCallback = "callback"
+)
+
+// recordEntry 是录制文件中的一行 JSON
+type recordEntry struct {
+	Kind string
+	Time time.Time
+	// Root 记录开始监听的根目录，Node 是当时的整棵树
+	Root string `json:",omitempty"`
+	// Path 和 Op 是后端的原始事件，Node 是收到事件时 Path 的状态，已经不存在时为 nil
+	Path string      `json:",omitempty"`
+	Op   fsnotify.Op `json:",omitempty"`
+	Node *fs.Node    `json:",omitempty"`
+	// Shallow 表示 Node 只描述 Path 本身，不包含目录下的内容
+	Shallow bool `json:",omitempty"`
+	// Event 是交给回调的事件
+	Event *CallBackEvent `json:",omitempty"`
+}
+
+// Recorder 把根目录的初始状态、后端的原始事件以及回调事件录制下来，之后可以用 Replayer 重放。
+// 文件只记录大小、权限和修改时间，不记录内容；事件只记录路径本身，整体移入的目录重放时是空的
+type Recorder struct {
+	locker sync.Mutex
+	enc    *json.Encoder
+	err    error
+}
+
+// NewRecorder 创建写入 w 的 Recorder，每条记录是一行 JSON，w 由调用方关闭
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Err 返回第一次写入失败的错误，失败之后不再录制
+func (rec *Recorder) Err() error {
+	rec.locker.Lock()
+	defer rec.locker.Unlock()
+	return rec.err
+}
+
+func (rec *Recorder) write(entry recordEntry) {
+	rec.locker.Lock()
+	defer rec.locker.Unlock()
+
+	if rec.err != nil {
+		return
+	}
+	rec.err = rec.enc.Encode(entry)
+}
+
+func (rec *Recorder) root(rootPath string, tree *fs.FileSystem) {
+	entry := recordEntry{Kind: recordRoot, Time: time.Now(), Root: rootPath}
+	if tree != nil {
+		entry.Node = tree.Root
+	}
+	rec.write(entry)
+}
+
+func (rec *Recorder) event(event BackendEvent) {
+	// 在事件循环上执行，只记录路径本身，扫描整个目录会拖住所有事件的处理。
+	// 失败一般是路径正在被删除，按不存在记录
+	node, _ := fs.Lstat(event.Path)
+	rec.write(recordEntry{Kind: recordEvent, Time: event.Time, Path: event.Path, Op: event.Op, Node: node, Shallow: true})
+}
+
+func (rec *Recorder) callback(cbe CallBackEvent) {
+	rec.write(recordEntry{Kind: recordCallback, Time: cbe.DeliveredAt, Event: &cbe})
+}
@@ -0,0 +1,343 @@
+package rxfsnotify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/atmshang/rxfsnotify/fs"
+	"github.com/fsnotify/fsnotify"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Replayer 把 Recorder 录制的会话重放到一个新的 Watcher 中。
+// 每个录制的根目录会在沙盒目录中按录制时的状态重建，原始事件按顺序应用到沙盒上，
+// 再交给和实时监听相同的流水线（快照更新、过滤、对比、投递）
+type Replayer struct {
+	entries []recordEntry
+}
+
+// NewReplayer 读取 Recorder 写入的录制内容
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	p := &Replayer{}
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var entry recordEntry
+		err := dec.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return p, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.entries = append(p.entries, entry)
+	}
+}
+
+// Recorded 返回录制时交给回调的事件，路径是录制时的路径，可以用来和重放的结果对比
+func (p *Replayer) Recorded() []CallBackEvent {
+	var result []CallBackEvent
+	for _, entry := range p.entries {
+		if entry.Kind == recordCallback && entry.Event != nil {
+			result = append(result, *entry.Event)
+		}
+	}
+	return result
+}
+
+// Run 在 sandbox 中重放录制的会话，第 i 个录制的根目录重建在 sandbox/i 下，事件的路径也是沙盒中的路径。
+// opts 用来创建重放用的 Watcher，例如 WithCallback、WithDebounce，后端总是由 Replayer 提供。
+// speed 为 1 时按录制时的节奏重放，2 时快一倍；speed <= 0 时不等待，每个事件都等流水线空闲后再应用下一个，
+// 防抖按录制的时间计算，结果是确定的。所有事件应用完之后立即对比并等待回调结束再返回
+func (p *Replayer) Run(ctx context.Context, sandbox string, speed float64, opts ...Option) error {
+	w, err := New(append(opts, WithBackend(replayBackend{}))...)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	sandbox, err = filepath.Abs(sandbox)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(sandbox, 0o755); err != nil {
+		return err
+	}
+	if err := w.Start(ctx); err != nil {
+		return err
+	}
+
+	s := &replaySandbox{
+		dir:   sandbox,
+		roots: make(map[string]string),
+		inos:  make(map[string]uint64),
+		limbo: make(map[uint64]string),
+	}
+	// speed <= 0 时按录制的时间模拟每个根目录的防抖
+	type virtualClock struct {
+		first, last time.Time
+	}
+	clocks := make(map[*watchedRoot]*virtualClock)
+
+	var last time.Time
+	for _, entry := range p.entries {
+		if speed > 0 && !last.IsZero() && entry.Time.After(last) {
+			if !sleepContext(ctx, time.Duration(float64(entry.Time.Sub(last))/speed)) {
+				return ctx.Err()
+			}
+		}
+		if !entry.Time.IsZero() {
+			last = entry.Time
+		}
+
+		switch entry.Kind {
+		case recordRoot:
+			dir, added := s.root(entry.Root)
+			if err := s.apply(dir, entry.Node, true); err != nil {
+				return err
+			}
+			if added {
+				if err := w.AddRoot(dir); err != nil {
+					return err
+				}
+			}
+		case recordEvent:
+			path, ok := s.path(entry.Path)
+			if !ok {
+				continue
+			}
+			// 录制时在这个事件之前已经到期的防抖先对比掉
+			for r, c := range clocks {
+				quiet := entry.Time.Sub(c.last) >= r.opts.debounce
+				if quiet || r.opts.maxWait > 0 && entry.Time.Sub(c.first) >= r.opts.maxWait {
+					r.flushQueue.CancelAll()
+					w.flush(r)
+					delete(clocks, r)
+				}
+			}
+			if entry.Node == nil && entry.Op.Has(fsnotify.Rename) {
+				err = s.moveAway(path)
+			} else {
+				err = s.apply(path, entry.Node, !entry.Shallow)
+			}
+			if err != nil {
+				return err
+			}
+			w.singleLineOptSnapshot(w.runCtx, path, entry.Time)
+			if speed <= 0 {
+				if err := w.waitIdle(ctx); err != nil {
+					return err
+				}
+				if r := w.findRoot(path); r != nil {
+					c, ok := clocks[r]
+					if !ok {
+						c = &virtualClock{first: entry.Time}
+						clocks[r] = c
+					}
+					c.last = entry.Time
+				}
+			}
+		}
+	}
+	return w.drain(ctx)
+}
+
+// waitIdle 等到没有正在进行的快照更新、对比和投递
+func (w *Watcher) waitIdle(ctx context.Context) error {
+	for w.inflight.Load() > 0 {
+		if !sleepContext(ctx, time.Millisecond) {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// drain 立即对比所有还在等待的根目录，并等待投递完成
+func (w *Watcher) drain(ctx context.Context) error {
+	for {
+		pending := false
+		for _, r := range w.rootList() {
+			r.flushLocker.Lock()
+			scheduled := !r.pendingSince.IsZero()
+			r.flushLocker.Unlock()
+			if scheduled {
+				pending = true
+				r.flushQueue.CancelAll()
+				w.flush(r)
+			}
+		}
+		if !pending && w.inflight.Load() == 0 {
+			return nil
+		}
+		if !sleepContext(ctx, time.Millisecond) {
+			return ctx.Err()
+		}
+	}
+}
+
+// replaySandbox 是重放用的假文件系统，按录制的节点状态创建目录和文件
+type replaySandbox struct {
+	dir string
+	// roots 把录制的根目录映射到沙盒中的目录
+	roots map[string]string
+	// inos 记录沙盒路径对应的录制时的 inode，limbo 保存被移走但还没出现在新位置的节点，
+	// 这样重放的移动仍然是同一个 inode，能被识别为 Renamed
+	inos  map[string]uint64
+	limbo map[uint64]string
+}
+
+func (s *replaySandbox) root(rootPath string) (string, bool) {
+	if dir, ok := s.roots[rootPath]; ok {
+		return dir, false
+	}
+	dir := filepath.Join(s.dir, strconv.Itoa(len(s.roots)))
+	s.roots[rootPath] = dir
+	return dir, true
+}
+
+// path 把录制的路径映射到沙盒中
+func (s *replaySandbox) path(recorded string) (string, bool) {
+	found, dir := "", ""
+	for rootPath, d := range s.roots {
+		if isSubPath(rootPath, recorded) && len(rootPath) > len(found) {
+			found, dir = rootPath, d
+		}
+	}
+	if found == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(found, recorded)
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(dir, rel), true
+}
+
+// moveAway 把被移走的节点放到沙盒的根目录之外，等它在新位置出现
+func (s *replaySandbox) moveAway(path string) error {
+	ino, ok := s.inos[path]
+	if _, err := os.Lstat(path); err != nil || !ok || ino == 0 {
+		return s.apply(path, nil, true)
+	}
+	limboDir := filepath.Join(s.dir, ".limbo")
+	if err := os.MkdirAll(limboDir, 0o755); err != nil {
+		return err
+	}
+	if old, ok := s.limbo[ino]; ok {
+		_ = os.RemoveAll(old)
+	}
+	target := filepath.Join(limboDir, strconv.FormatUint(ino, 10))
+	if err := os.Rename(path, target); err != nil {
+		return err
+	}
+	s.limbo[ino] = target
+	delete(s.inos, path)
+	return nil
+}
+
+// apply 让 path 变成 node 描述的状态，node 为 nil 时删除。
+// children 为 false 时 node 不包含目录下的内容，已有的内容保持不变
+func (s *replaySandbox) apply(path string, node *fs.Node, children bool) error {
+	info, err := os.Lstat(path)
+	exists := err == nil
+	if node == nil {
+		delete(s.inos, path)
+		return os.RemoveAll(path)
+	}
+	if node.IsSymlink() && node.Target != "" {
+		return s.applySymlink(path, exists, node)
+	}
+	// 已有的符号链接也要删掉，否则会写到链接的目标里
+	if exists && (info.IsDir() == node.IsFile || info.Mode()&os.ModeSymlink != 0) {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		exists = false
+	}
+	if moved, ok := s.limbo[node.Ino]; ok && !exists && node.Ino != 0 {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.Rename(moved, path); err != nil {
+			return err
+		}
+		delete(s.limbo, node.Ino)
+	}
+	s.inos[path] = node.Ino
+
+	if node.IsFile {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		err = f.Truncate(node.Size)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			return err
+		}
+		names := make(map[string]bool)
+		for _, child := range node.Children {
+			names[child.Name] = true
+			if err := s.apply(filepath.Join(path, child.Name), child, true); err != nil {
+				return err
+			}
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if children && !names[entry.Name()] {
+				if err := s.apply(filepath.Join(path, entry.Name()), nil, true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if node.Mode != 0 {
+		if err := os.Chmod(path, node.Mode.Perm()); err != nil {
+			return err
+		}
+	}
+	return os.Chtimes(path, node.ModTime, node.ModTime)
+}
+
+// applySymlink 让 path 成为指向 node.Target 的符号链接，指向的内容不属于这个路径，不会被重建
+func (s *replaySandbox) applySymlink(path string, exists bool, node *fs.Node) error {
+	if exists {
+		if target, err := os.Readlink(path); err == nil && target == node.Target {
+			return nil
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	s.inos[path] = node.Ino
+	return os.Symlink(node.Target, path)
+}
+
+// replayBackend 不产生任何事件，重放时事件由 Replayer 直接送入流水线
+type replayBackend struct{}
+
+func (replayBackend) Add(string, fs.Filter) error { return nil }
+func (replayBackend) Remove(string) error         { return nil }
+func (replayBackend) Events() <-chan BackendEvent { return nil }
+func (replayBackend) Errors() <-chan error        { return nil }
+func (replayBackend) Close() error                { return nil }
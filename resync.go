@@ -0,0 +1,55 @@
+package rxfsnotify
+
+import (
+	"github.com/atmshang/plog"
+	"runtime/debug"
+)
+
+// IOverflowCallback 可以由回调对象额外实现，在根目录因为事件丢失而重新扫描之前被调用
+type IOverflowCallback interface {
+	OnOverflow(root string)
+}
+
+// resyncBackend 重新扫描使用 backend 的所有根目录
+func (w *Watcher) resyncBackend(backend Backend, cause error) {
+	for _, r := range w.rootList() {
+		if r.backend != backend {
+			continue
+		}
+		w.inflight.Add(1)
+		go func(r *watchedRoot) {
+			defer w.inflight.Add(-1)
+			w.resync(r, cause)
+		}(r)
+	}
+}
+
+// resync 在事件丢失后重新扫描根目录，和快照的差异以 Resync 事件上报，
+// 同时通过 Errors 通道和 IOverflowCallback 通知使用方
+func (w *Watcher) resync(r *watchedRoot, cause error) {
+	plog.Println("事件丢失，重新扫描：", r.path, cause)
+	w.channel.publishError(&RootError{Root: r.path, Kind: ErrOverflow, Err: cause})
+	if oc, ok := w.cb.(IOverflowCallback); ok {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					debug.PrintStack()
+				}
+			}()
+			oc.OnOverflow(r.path)
+		}()
+	}
+
+	// 已经观察到的变化仍然按普通事件上报，剩下的差异才是丢失的事件
+	r.flushQueue.CancelAll()
+	w.flush(r)
+
+	err := r.snapshot.Rescan()
+	if err != nil {
+		plog.Println("重新扫描失败：", r.path, err)
+		w.channel.publishError(classifyError(r.path, err))
+		return
+	}
+	w.dispatch(r, r.snapshot.DiffAndSync(), nil, sourceResync)
+	w.stateDirty.Store(true)
+}
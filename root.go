@@ -0,0 +1,381 @@
+package rxfsnotify
+
+import (
+	"github.com/atmshang/plog"
+	"github.com/atmshang/rxfsnotify/concurrent"
+	"github.com/atmshang/rxfsnotify/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchedRoot 是一个被监听的根目录，每个根目录维护自己的快照
+type watchedRoot struct {
+	path     string
+	opts     options
+	backend  Backend
+	snapshot fs.Snapshot
+
+	// 推迟对比的任务队列，pendingSince 是第一个未对比的变化到达的时间
+	flushQueue   *concurrent.TaskQueue
+	flushLocker  sync.Mutex
+	pendingSince time.Time
+	// observed 记录本批变化中每个路径最早被后端观察到的时间
+	observed map[string]time.Time
+
+	// fallback 是监听数量达到上限时轮询 fallbackDirs 的后端
+	fallback     Backend
+	fallbackDirs []string
+
+	// early 是运行中添加、还没扫描完时收到的事件，由 rootsLocker 保护
+	early []earlyEvent
+}
+
+type earlyEvent struct {
+	path       string
+	observedAt time.Time
+}
+
+func cleanRootPath(rootPath string) (string, error) {
+	absPath, err := filepath.Abs(rootPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Clean(absPath), nil
+}
+
+// 判断 p 是否等于 dir 或位于 dir 之下
+func isSubPath(dir string, p string) bool {
+	if p == dir {
+		return true
+	}
+	return strings.HasPrefix(p, strings.TrimSuffix(dir, string(os.PathSeparator))+string(os.PathSeparator))
+}
+
+// AddRoot 添加一个根目录，运行中添加会立即建立快照并开始监听，失败时返回 *RootError。
+// opts 只对这个根目录生效，会覆盖 New 时的同名选项，例如用 WithBackend 为它单独指定后端。
+func (w *Watcher) AddRoot(rootPath string, opts ...Option) error {
+	rootPath, err := cleanRootPath(rootPath)
+	if err != nil {
+		return err
+	}
+
+	r := &watchedRoot{path: rootPath, opts: w.opts, flushQueue: concurrent.NewTaskQueue()}
+	for _, opt := range opts {
+		opt(&r.opts)
+	}
+	r.backend = r.opts.backend
+	if r.backend == nil {
+		r.backend = w.backend
+	}
+
+	w.rootsLocker.Lock()
+	if _, ok := w.roots[rootPath]; ok || w.adding[rootPath] != nil {
+		w.rootsLocker.Unlock()
+		return &RootError{Root: rootPath, Kind: ErrRootExists}
+	}
+	w.adding[rootPath] = r
+	running := w.running
+	w.rootsLocker.Unlock()
+
+	var catchUp []fs.Diff
+	if running {
+		catchUp, err = w.startAddedRoot(r)
+	}
+
+	w.rootsLocker.Lock()
+	delete(w.adding, rootPath)
+	early := r.early
+	r.early = nil
+	switch {
+	case err != nil:
+	case running && !w.running:
+		// 添加期间停止了，下次 Start 时重新建立快照
+		w.stopRoot(r)
+		catchUp, early = nil, nil
+	case !running && w.running:
+		// 添加期间开始运行了，这种情况很少见，直接在锁内启动
+		catchUp, err = w.startRoot(r)
+	}
+	if err != nil {
+		w.rootsLocker.Unlock()
+		return err
+	}
+	w.roots[rootPath] = r
+	ctx := w.runCtx
+	w.rootsLocker.Unlock()
+
+	w.dispatch(r, catchUp, nil, sourceCatchUp)
+	// 扫描期间收到的事件等根目录就绪之后再处理
+	for _, e := range early {
+		w.singleLineOptSnapshot(ctx, e.path, e.observedAt)
+	}
+	w.stateDirty.Store(true)
+	return nil
+}
+
+// RemoveRoot 移除一个根目录，不再上报它下面的变化
+func (w *Watcher) RemoveRoot(rootPath string) error {
+	rootPath, err := cleanRootPath(rootPath)
+	if err != nil {
+		return err
+	}
+
+	w.rootsLocker.Lock()
+	defer w.rootsLocker.Unlock()
+
+	r, ok := w.roots[rootPath]
+	if !ok {
+		return &RootError{Root: rootPath, Kind: ErrRootNotWatched}
+	}
+	delete(w.roots, rootPath)
+	if w.running {
+		w.stopRoot(r)
+	}
+	w.stateDirty.Store(true)
+	return nil
+}
+
+// Roots 返回当前所有的根目录
+func (w *Watcher) Roots() []string {
+	w.rootsLocker.RLock()
+	defer w.rootsLocker.RUnlock()
+
+	var result []string
+	for p := range w.roots {
+		result = append(result, p)
+	}
+	return result
+}
+
+// startRoot 开始监听并建立快照，如果状态文件里有上次的快照，返回离线期间的变化。调用方持有 rootsLocker。
+// 先监听再扫描，扫描期间的变化不会漏掉
+func (w *Watcher) startRoot(r *watchedRoot) ([]fs.Diff, error) {
+	if err := checkRoot(r.path); err != nil {
+		return nil, err
+	}
+	if err := w.watchRootLocked(r, r.addRoot(r.backend, r.path, r.opts.filter)); err != nil {
+		return nil, err
+	}
+	catchUp, err := w.scanRoot(r)
+	if err != nil {
+		w.stopRoot(r)
+		return nil, err
+	}
+	return catchUp, nil
+}
+
+// startAddedRoot 和 startRoot 一样先监听再扫描，用于运行中添加的根目录。
+// 监听和扫描都很慢，只在开始消费后端时短暂持有 rootsLocker，否则所有根目录的事件处理都会被阻塞
+func (w *Watcher) startAddedRoot(r *watchedRoot) ([]fs.Diff, error) {
+	if err := checkRoot(r.path); err != nil {
+		return nil, err
+	}
+	addErr := r.addRoot(r.backend, r.path, r.opts.filter)
+	w.rootsLocker.Lock()
+	err := w.watchRootLocked(r, addErr)
+	w.rootsLocker.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	catchUp, err := w.scanRoot(r)
+	if err != nil {
+		w.rootsLocker.Lock()
+		w.stopRoot(r)
+		w.rootsLocker.Unlock()
+		return nil, err
+	}
+	return catchUp, nil
+}
+
+// checkRoot 确认根目录存在并且是目录
+func checkRoot(rootPath string) error {
+	stat, err := os.Stat(rootPath)
+	if err != nil {
+		return classifyError(rootPath, err)
+	}
+	if !stat.IsDir() {
+		return &RootError{Root: rootPath, Kind: ErrRootNotDir}
+	}
+	return nil
+}
+
+// scanRoot 建立根目录的快照，不需要持有 rootsLocker
+func (w *Watcher) scanRoot(r *watchedRoot) ([]fs.Diff, error) {
+	var catchUp []fs.Diff
+	var err error
+	if previous := w.takePreviousState(r.path); previous != nil {
+		catchUp, err = r.snapshot.Restore(previous, r.path, r.opts.fsOptions()...)
+	} else {
+		err = r.snapshot.Init(r.path, r.opts.fsOptions()...)
+	}
+	if err != nil {
+		return nil, classifyError(r.path, err)
+	}
+	if w.opts.recorder != nil {
+		w.opts.recorder.root(r.path, r.snapshot.Synced())
+	}
+	return catchUp, nil
+}
+
+// watchRootLocked 根据后端 Add 的结果 err 开始消费根目录的事件，调用方持有 rootsLocker
+func (w *Watcher) watchRootLocked(r *watchedRoot, err error) error {
+	if err != nil && isWatchLimit(err) && r.opts.pollingFallback > 0 {
+		// 根目录本身都无法监听时整个根目录改为轮询
+		plog.Println("监听数量达到上限，改为轮询：", r.path)
+		r.fallback = w.pollingFallbackLocked(r.opts.pollingFallback)
+		r.fallbackDirs = []string{r.path}
+		err = r.addRoot(r.fallback, r.path, r.opts.filter)
+	}
+	if err != nil {
+		return classifyError(r.path, err)
+	}
+	w.consumeBackendLocked(r.backend)
+	r.flushQueue.Start()
+	return nil
+}
+
+// stopRoot 停止监听并丢弃还没对比的变化，下次启动时重新建立快照
+func (w *Watcher) stopRoot(r *watchedRoot) {
+	_ = r.backend.Remove(r.path)
+	for _, dir := range r.fallbackDirs {
+		_ = r.fallback.Remove(dir)
+	}
+	r.fallback, r.fallbackDirs = nil, nil
+	r.flushQueue.Stop()
+
+	r.flushLocker.Lock()
+	r.pendingSince = time.Time{}
+	r.observed = nil
+	r.flushLocker.Unlock()
+}
+
+// 找到包含 p 的根目录，根目录嵌套时取最深的那个
+func (w *Watcher) findRoot(p string) *watchedRoot {
+	w.rootsLocker.RLock()
+	defer w.rootsLocker.RUnlock()
+	return w.findRootLocked(p)
+}
+
+// rootForEvent 找到事件所属的根目录。属于运行中添加、还没扫描完的根目录时先记下事件，
+// 等它就绪之后再处理，返回 nil
+func (w *Watcher) rootForEvent(p string, observedAt time.Time) *watchedRoot {
+	w.rootsLocker.RLock()
+	if len(w.adding) == 0 {
+		defer w.rootsLocker.RUnlock()
+		return w.findRootLocked(p)
+	}
+	w.rootsLocker.RUnlock()
+
+	w.rootsLocker.Lock()
+	defer w.rootsLocker.Unlock()
+	found := w.findRootLocked(p)
+	for rootPath, r := range w.adding {
+		if isSubPath(rootPath, p) && (found == nil || len(rootPath) > len(found.path)) {
+			r.early = append(r.early, earlyEvent{path: p, observedAt: observedAt})
+			return nil
+		}
+	}
+	return found
+}
+
+func (w *Watcher) findRootLocked(p string) *watchedRoot {
+	var found *watchedRoot
+	for rootPath, r := range w.roots {
+		if isSubPath(rootPath, p) && (found == nil || len(rootPath) > len(found.path)) {
+			found = r
+		}
+	}
+	return found
+}
+
+func (w *Watcher) rootList() []*watchedRoot {
+	w.rootsLocker.RLock()
+	defer w.rootsLocker.RUnlock()
+
+	var result []*watchedRoot
+	for _, r := range w.roots {
+		result = append(result, r)
+	}
+	return result
+}
+
+// filterReloader 由会读取树中配置文件的过滤器实现，例如 filter.Filter 读取 .gitignore
+type filterReloader interface {
+	// Reload 在 absPath 是过滤器的配置文件时丢弃缓存并返回 true
+	Reload(absPath string) bool
+}
+
+// watchRefresher 由按过滤器决定监听哪些目录的后端实现，例如 fsnotify 后端。
+// 轮询后端每次扫描都使用过滤器最新的规则，不需要实现
+type watchRefresher interface {
+	// Refresh 按过滤器现在的规则重新监听 dir 下的目录
+	Refresh(dir string)
+}
+
+// refreshWatches 在忽略规则变化后让根目录用到的后端重新监听 dir
+func (w *Watcher) refreshWatches(r *watchedRoot, dir string) {
+	w.rootsLocker.RLock()
+	backends := []Backend{r.backend, r.fallback}
+	w.rootsLocker.RUnlock()
+
+	for _, backend := range backends {
+		if refresher, ok := backend.(watchRefresher); ok {
+			refresher.Refresh(dir)
+		}
+	}
+}
+
+// filterDiff 在上报前再过滤一次，比如状态文件里的快照可能是用旧规则建立的。
+// 重命名只有一端被排除时，按另一端的出现或消失上报
+func (r *watchedRoot) filterDiff(diff fs.Diff) (fs.Diff, bool) {
+	if r.opts.filter == nil {
+		return diff, true
+	}
+	excluded := r.opts.filter.Excluded(r.path, diff.AbsPath, diff.IsDir)
+	if diff.Op != fs.Renamed {
+		return diff, !excluded
+	}
+	oldExcluded := r.opts.filter.Excluded(r.path, diff.OldAbsPath, diff.IsDir)
+	switch {
+	case excluded && oldExcluded:
+		return diff, false
+	case excluded:
+		diff.Op, diff.AbsPath, diff.Path = fs.Removed, diff.OldAbsPath, diff.OldPath
+	case oldExcluded:
+		diff.Op = fs.Created
+	default:
+		return diff, true
+	}
+	diff.OldAbsPath, diff.OldPath = "", ""
+	return diff, true
+}
+
+// observe 记录 path 在本批变化中最早被观察到的时间
+func (r *watchedRoot) observe(path string, t time.Time) {
+	r.flushLocker.Lock()
+	defer r.flushLocker.Unlock()
+
+	if r.observed == nil {
+		r.observed = make(map[string]time.Time)
+	}
+	if old, ok := r.observed[path]; !ok || t.Before(old) {
+		r.observed[path] = t
+	}
+}
+
+// observedAt 查找 path 或者离它最近的被观察到的上级目录的时间，找不到时返回 fallback
+func observedAt(observed map[string]time.Time, path string, fallback time.Time) time.Time {
+	for {
+		if t, ok := observed[path]; ok {
+			return t
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return fallback
+		}
+		path = parent
+	}
+}
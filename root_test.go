@@ -0,0 +1,72 @@
+package rxfsnotify
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 根目录要先监听再扫描：监听期间出现的文件已经在快照里，它的事件不会再报告新建。
+// 先扫描的话它不在快照里，之后它的事件才会报告新建
+func TestRootWatchedBeforeScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		running bool
+	}{
+		{name: "start", running: false},
+		{name: "add while running", running: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			backend := newFakeBackend()
+			backend.onAdd = func(path string) {
+				if path == root {
+					if err := os.WriteFile(filepath.Join(root, "a"), nil, 0o644); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+			w, err := New(WithBackend(backend), WithDebounce(10*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			events := w.Events()
+			if tt.running {
+				if err := w.Start(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.AddRoot(root); err != nil {
+					t.Fatal(err)
+				}
+			} else if err := w.Start(context.Background(), root); err != nil {
+				t.Fatal(err)
+			}
+
+			file := filepath.Join(root, "b")
+			if err := os.WriteFile(file, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			// 后端也会上报监听期间出现的 a
+			backend.events <- BackendEvent{Path: filepath.Join(root, "a"), Op: fsnotify.Create}
+			backend.events <- BackendEvent{Path: file, Op: fsnotify.Create}
+			select {
+			case e := <-events:
+				if e.Op != Created || e.Path != file {
+					t.Errorf("got %v %s, want Created %s", e.Op, e.Path, file)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no event")
+			}
+			select {
+			case e := <-events:
+				t.Errorf("unexpected %v %s", e.Op, e.Path)
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package rxfsnotify
+
+import (
+	"context"
+	"errors"
+	"github.com/atmshang/plog"
+	"github.com/atmshang/rxfsnotify/fs"
+	iofs "io/fs"
+	"time"
+)
+
+// loadState 在每次启动时读取状态文件，文件不存在不算错误。
+// 停止时会保存状态文件，所以 Stop 之后再 Start 也能补报停止期间的变化
+func (w *Watcher) loadState() error {
+	w.stateFileLock.Lock()
+	defer w.stateFileLock.Unlock()
+
+	if w.opts.stateFile == "" {
+		return nil
+	}
+	trees, err := fs.LoadFile(w.opts.stateFile)
+	if err != nil && !errors.Is(err, iofs.ErrNotExist) {
+		return &RootError{Root: w.opts.stateFile, Err: err}
+	}
+	w.previousState = make(map[string]*fs.FileSystem)
+	for _, tree := range trees {
+		w.previousState[tree.Root.AbsPath] = tree
+	}
+	return nil
+}
+
+// takePreviousState 取出 rootPath 上次保存的快照，每个快照只会被使用一次
+func (w *Watcher) takePreviousState(rootPath string) *fs.FileSystem {
+	w.stateFileLock.Lock()
+	defer w.stateFileLock.Unlock()
+
+	previous := w.previousState[rootPath]
+	delete(w.previousState, rootPath)
+	return previous
+}
+
+// stateSaver 每隔 stateSaveInterval 检查一次，有新的变化被上报时才保存，
+// 每批变化都写出整个快照的话，大目录树上保存的开销会远大于对比本身
+func (w *Watcher) stateSaver(ctx context.Context) {
+	defer w.wg.Done()
+
+	interval := w.opts.stateSaveInterval
+	if interval <= 0 {
+		interval = defaultOptions().stateSaveInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if w.stateDirty.Swap(false) {
+				w.saveState()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// saveState 保存所有根目录已上报的快照
+func (w *Watcher) saveState() {
+	if w.opts.stateFile == "" {
+		return
+	}
+	// 先取根目录再加锁：AddRoot 持有 rootsLocker 时会等待 stateFileLock
+	roots := w.rootList()
+
+	w.stateFileLock.Lock()
+	defer w.stateFileLock.Unlock()
+
+	var trees []*fs.FileSystem
+	for _, r := range roots {
+		if tree := r.snapshot.Synced(); tree != nil {
+			trees = append(trees, tree)
+		}
+	}
+	err := fs.SaveFile(w.opts.stateFile, trees...)
+	if err != nil {
+		plog.Println("保存状态文件失败：", err)
+		w.channel.publishError(err)
+	}
+}
@@ -0,0 +1,56 @@
+package rxfsnotify
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestartCatchesUpWithStateFile(t *testing.T) {
+	root := t.TempDir()
+	stateFile := filepath.Join(t.TempDir(), "state")
+	if err := os.WriteFile(filepath.Join(root, "a"), []byte("1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := New(WithStateFile(stateFile), WithDebounce(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	events := w.Events()
+
+	if err := w.Start(context.Background(), root); err != nil {
+		t.Fatal(err)
+	}
+	w.Stop()
+
+	if err := os.WriteFile(filepath.Join(root, "a"), []byte("22"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "b"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]Op)
+	timeout := time.After(5 * time.Second)
+	for len(got) < 2 {
+		select {
+		case e := <-events:
+			if !e.CatchUp {
+				t.Fatalf("%v %s is not a catch-up event", e.Op, e.Path)
+			}
+			got[e.Path] = e.Op
+		case <-timeout:
+			t.Fatalf("got %v, want the changes made while stopped", got)
+		}
+	}
+	if got[filepath.Join(root, "a")] != Modified || got[filepath.Join(root, "b")] != Created {
+		t.Errorf("got %v, want a modified and b created", got)
+	}
+}
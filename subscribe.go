@@ -0,0 +1,209 @@
+package rxfsnotify
+
+import (
+	"github.com/atmshang/rxfsnotify/fs"
+	"runtime/debug"
+	"sync"
+)
+
+// OpMask 是一组 Op，零值表示接收所有类型的事件
+type OpMask uint32
+
+// Ops 把几个 Op 组合成 OpMask
+func Ops(ops ...Op) OpMask {
+	var m OpMask
+	for _, op := range ops {
+		m |= 1 << uint(op)
+	}
+	return m
+}
+
+// Has 判断 op 是否在 m 中
+func (m OpMask) Has(op Op) bool {
+	return m == 0 || m&(1<<uint(op)) != 0
+}
+
+// SubscriptionFilter 决定订阅者收到哪些事件，零值表示接收所有事件
+type SubscriptionFilter struct {
+	// Filter 排除的路径不会投递给订阅者，例如 filter.New 创建的过滤器。
+	// 移入被排除的路径视为 Removed，从被排除的路径移出视为 Created
+	Filter fs.Filter
+	// Ops 是订阅者关心的事件类型
+	Ops OpMask
+}
+
+// PathCallbackFunc 让普通函数实现 IPathCallback
+type PathCallbackFunc func(cbe CallBackEvent)
+
+func (f PathCallbackFunc) OnPathChanged(cbe CallBackEvent) {
+	f(cbe)
+}
+
+// Subscription 是 Subscribe 返回的订阅
+type Subscription interface {
+	// Unsubscribe 停止投递，还没投递的事件会被丢弃，可以在 handler 中调用
+	Unsubscribe()
+}
+
+// subscription 有自己的队列和投递协程，慢的订阅者只会让自己的队列变长，不会拖慢其他订阅者
+type subscription struct {
+	w       *Watcher
+	filter  SubscriptionFilter
+	handler IPathCallback
+
+	locker sync.Mutex
+	queue  []CallBackEvent
+	wake   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	// onExit 在投递协程退出时调用
+	onExit func()
+}
+
+// Subscribe 注册一个独立的订阅者，可以和回调、Events 通道同时使用，也可以在运行中调用。
+// handler 在订阅者自己的协程中按事件顺序调用
+func (w *Watcher) Subscribe(filter SubscriptionFilter, handler IPathCallback) (Subscription, error) {
+	if handler == nil {
+		return nil, ErrNilHandler
+	}
+
+	s := w.newSubscription(filter, handler)
+	if err := w.addSubscription(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (w *Watcher) newSubscription(filter SubscriptionFilter, handler IPathCallback) *subscription {
+	return &subscription{
+		w:       w,
+		filter:  filter,
+		handler: handler,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// addSubscription 登记订阅者并启动它的投递协程
+func (w *Watcher) addSubscription(s *subscription) error {
+	w.subsLocker.Lock()
+	defer w.subsLocker.Unlock()
+
+	if w.subsClosed {
+		return ErrClosed
+	}
+	w.subs[s] = struct{}{}
+	go s.deliver()
+	return nil
+}
+
+func (s *subscription) Unsubscribe() {
+	s.w.subsLocker.Lock()
+	delete(s.w.subs, s)
+	s.w.subsLocker.Unlock()
+	s.stop()
+}
+
+func (s *subscription) stop() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
+
+// accept 按订阅者的过滤条件调整事件，不需要投递时返回 false
+func (s *subscription) accept(cbe CallBackEvent) (CallBackEvent, bool) {
+	f := s.filter.Filter
+	if f != nil {
+		excluded := f.Excluded(cbe.Root, cbe.Path, cbe.IsDir)
+		if cbe.Op != Renamed {
+			if excluded {
+				return cbe, false
+			}
+		} else {
+			oldExcluded := f.Excluded(cbe.Root, cbe.OldPath, cbe.IsDir)
+			switch {
+			case excluded && oldExcluded:
+				return cbe, false
+			case excluded:
+				cbe.Op, cbe.Path, cbe.OldPath, cbe.Exist = Removed, cbe.OldPath, "", false
+			case oldExcluded:
+				cbe.Op, cbe.OldPath = Created, ""
+			}
+		}
+	}
+	return cbe, s.filter.Ops.Has(cbe.Op)
+}
+
+func (s *subscription) publish(cbe CallBackEvent) {
+	cbe, ok := s.accept(cbe)
+	if !ok {
+		return
+	}
+
+	s.locker.Lock()
+	s.queue = append(s.queue, cbe)
+	s.locker.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscription) deliver() {
+	if s.onExit != nil {
+		defer s.onExit()
+	}
+
+	for {
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+
+		s.locker.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.locker.Unlock()
+
+		for _, cbe := range queue {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			s.call(cbe)
+		}
+	}
+}
+
+func (s *subscription) call(cbe CallBackEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			debug.PrintStack()
+		}
+	}()
+	s.handler.OnPathChanged(cbe)
+}
+
+// 把事件交给所有订阅者
+func (w *Watcher) publishSubscribers(cbe CallBackEvent) {
+	w.subsLocker.RLock()
+	defer w.subsLocker.RUnlock()
+
+	for s := range w.subs {
+		s.publish(cbe)
+	}
+}
+
+// 停止所有订阅者，之后不能再订阅
+func (w *Watcher) closeSubscribers() {
+	w.subsLocker.Lock()
+	defer w.subsLocker.Unlock()
+
+	w.subsClosed = true
+	for s := range w.subs {
+		s.stop()
+	}
+	w.subs = nil
+}
@@ -1,6 +1,7 @@
 package rxfsnotify
 
 import (
+	"context"
 	"os"
 	"time"
 )
@@ -17,6 +18,18 @@ func isValidFile(filePath string) bool {
 	return true
 }
 
+// statPath 和快照一样判断路径的类型：follow 时按符号链接指向的目标，否则按链接本身，
+// 链接失效时也按链接本身
+func statPath(p string, follow bool) (os.FileInfo, error) {
+	info, err := os.Lstat(p)
+	if err == nil && follow && info.Mode()&os.ModeSymlink != 0 {
+		if target, err := os.Stat(p); err == nil {
+			return target, nil
+		}
+	}
+	return info, err
+}
+
 // 判断文件是否处于空闲状态
 func isIdleFile(filePath string) bool {
 	noNeedFile, err := os.Open(filePath)
@@ -27,10 +40,12 @@ func isIdleFile(filePath string) bool {
 	return true
 }
 
-func checkFileUntilValidOrIdle(filePath string) bool {
+func checkFileUntilValidOrIdle(ctx context.Context, filePath string, interval time.Duration) bool {
 	for {
 		// 先等待一会儿
-		time.Sleep(250 * time.Millisecond)
+		if !sleepContext(ctx, interval) {
+			return isValidFile(filePath)
+		}
 
 		// 检查文件是否有效
 		if !isValidFile(filePath) {
@@ -45,3 +60,15 @@ func checkFileUntilValidOrIdle(filePath string) bool {
 		return true
 	}
 }
+
+// sleepContext 等待 d，ctx 先结束时返回 false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
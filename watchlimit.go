@@ -0,0 +1,148 @@
+package rxfsnotify
+
+import (
+	"errors"
+	"fmt"
+	"github.com/atmshang/plog"
+	"github.com/atmshang/rxfsnotify/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// InotifyLimits 是 /proc/sys/fs/inotify 中的限制
+type InotifyLimits struct {
+	MaxUserWatches   int
+	MaxUserInstances int
+	MaxQueuedEvents  int
+}
+
+// ReadInotifyLimits 读取当前的 inotify 限制，不是 Linux 时返回错误
+func ReadInotifyLimits() (InotifyLimits, error) {
+	var limits InotifyLimits
+	for name, value := range map[string]*int{
+		"max_user_watches":   &limits.MaxUserWatches,
+		"max_user_instances": &limits.MaxUserInstances,
+		"max_queued_events":  &limits.MaxQueuedEvents,
+	} {
+		data, err := os.ReadFile(filepath.Join("/proc/sys/fs/inotify", name))
+		if err != nil {
+			return limits, err
+		}
+		*value, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return limits, err
+		}
+	}
+	return limits, nil
+}
+
+// WatchLimitError 表示因为 inotify 监听数量达到上限，Dirs 中的目录没有被监听，
+// 可以用 errors.Is(err, ErrWatchLimit) 判断
+type WatchLimitError struct {
+	Dirs []string
+	// Limits 是发现问题时读取的限制，读取失败时为零值
+	Limits InotifyLimits
+}
+
+func (e *WatchLimitError) Error() string {
+	msg := fmt.Sprintf("%v: %d directories unwatched (max_user_watches=%d)",
+		ErrWatchLimit, len(e.Dirs), e.Limits.MaxUserWatches)
+	if len(e.Dirs) > 0 {
+		msg += ", first " + e.Dirs[0]
+	}
+	return msg
+}
+
+func (e *WatchLimitError) Unwrap() error {
+	return ErrWatchLimit
+}
+
+// Unwatched 返回因为 inotify 监听数量达到上限而没有被监听的目录，
+// 开启 WithPollingFallback 时这些目录由轮询覆盖
+func (w *Watcher) Unwatched() []string {
+	w.rootsLocker.RLock()
+	defer w.rootsLocker.RUnlock()
+
+	var result []string
+	for backend := range w.backends {
+		if b, ok := backend.(interface{ Unwatched() []string }); ok {
+			result = append(result, b.Unwatched()...)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// isWatchLimit 判断 Add 失败是不是因为达到了监听数量上限
+func isWatchLimit(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, ErrWatchLimit)
+}
+
+// pollingFallbackLocked 返回轮询间隔为 interval 的降级后端，调用方需要持有 rootsLocker
+func (w *Watcher) pollingFallbackLocked(interval time.Duration) Backend {
+	if w.fallbacks == nil {
+		w.fallbacks = make(map[time.Duration]Backend)
+	}
+	backend, ok := w.fallbacks[interval]
+	if !ok {
+		backend = NewPollingBackend(interval)
+		w.fallbacks[interval] = backend
+	}
+	w.consumeBackendLocked(backend)
+	return backend
+}
+
+// handleWatchLimit 在开启了 WithPollingFallback 的根目录上改为轮询没能监听的子树
+func (w *Watcher) handleWatchLimit(err *WatchLimitError) {
+	w.rootsLocker.Lock()
+	defer w.rootsLocker.Unlock()
+
+	// 只需要轮询最上层的目录，它们的子目录会被一起扫描
+	unwatched := make(map[string]bool)
+	for _, dir := range err.Dirs {
+		unwatched[dir] = true
+	}
+	for _, dir := range err.Dirs {
+		if unwatched[filepath.Dir(dir)] {
+			continue
+		}
+		r := w.findRootLocked(dir)
+		if r == nil || r.opts.pollingFallback <= 0 || r.polled(dir) {
+			continue
+		}
+		backend := w.pollingFallbackLocked(r.opts.pollingFallback)
+		addErr := r.addRoot(backend, dir, subtreeFilter{root: r.path, filter: r.opts.filter})
+		if addErr != nil {
+			plog.Println("降级为轮询失败：", dir, addErr)
+			continue
+		}
+		plog.Println("监听数量达到上限，改为轮询：", dir)
+		r.fallbackDirs = append(r.fallbackDirs, dir)
+		r.fallback = backend
+	}
+}
+
+// polled 判断 dir 是否已经被降级轮询覆盖
+func (r *watchedRoot) polled(dir string) bool {
+	for _, p := range r.fallbackDirs {
+		if isSubPath(p, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// subtreeFilter 让以子树为根的后端仍然按所属根目录的规则过滤
+type subtreeFilter struct {
+	root   string
+	filter fs.Filter
+}
+
+func (f subtreeFilter) Excluded(_ string, absPath string, isDir bool) bool {
+	return f.filter != nil && f.filter.Excluded(f.root, absPath, isDir)
+}
@@ -0,0 +1,109 @@
+package rxfsnotify
+
+import (
+	"context"
+	"github.com/atmshang/rxfsnotify/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWatchLimitErrorMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *WatchLimitError
+		want string
+	}{
+		{
+			name: "no directories",
+			err:  &WatchLimitError{},
+			want: "rxfsnotify: inotify watch limit reached: 0 directories unwatched (max_user_watches=0)",
+		},
+		{
+			name: "names the first directory",
+			err:  &WatchLimitError{Dirs: []string{"/r/a", "/r/b"}, Limits: InotifyLimits{MaxUserWatches: 8192}},
+			want: "rxfsnotify: inotify watch limit reached: 2 directories unwatched (max_user_watches=8192), first /r/a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("Error() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeBackend 只上报测试注入的事件和错误，自己不会发现任何变化
+type fakeBackend struct {
+	events chan BackendEvent
+	errors chan error
+	// onAdd 不为 nil 时在 Add 里调用
+	onAdd func(root string)
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{events: make(chan BackendEvent), errors: make(chan error)}
+}
+
+func (b *fakeBackend) Add(root string, _ fs.Filter) error {
+	if b.onAdd != nil {
+		b.onAdd(root)
+	}
+	return nil
+}
+
+func (b *fakeBackend) Remove(string) error         { return nil }
+func (b *fakeBackend) Events() <-chan BackendEvent { return b.events }
+func (b *fakeBackend) Errors() <-chan error        { return b.errors }
+func (b *fakeBackend) Close() error                { return nil }
+
+func TestWatchLimitFallsBackToPolling(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	backend := newFakeBackend()
+	w, err := New(WithBackend(backend), WithPollingFallback(20*time.Millisecond), WithDebounce(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	events := w.Events()
+	if err := w.Start(context.Background(), root); err != nil {
+		t.Fatal(err)
+	}
+
+	backend.errors <- &WatchLimitError{Dirs: []string{sub}}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w.rootsLocker.RLock()
+		r := w.roots[root]
+		polled := r.fallback != nil && slices.Equal(r.fallbackDirs, []string{sub})
+		w.rootsLocker.RUnlock()
+		if polled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the unwatched directory was not handed to the poller")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 后端什么都不上报，能收到事件说明是轮询发现的
+	file := filepath.Join(sub, "a")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-events:
+		if e.Op != Created || e.Path != file {
+			t.Errorf("got %v %s, want Created %s", e.Op, e.Path, file)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event from the poller")
+	}
+}